@@ -0,0 +1,240 @@
+package booksing
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Author is the canonical form of an author, books refer to it by ID
+type Author struct {
+	ID       string `storm:"id"`
+	Name     string
+	SortName string `storm:"index"`
+}
+
+// AuthorAlias maps a variant of a name to a canonical author
+type AuthorAlias struct {
+	ID       string `storm:"id"`
+	Name     string
+	AuthorID string `storm:"index"`
+}
+
+var authorSeparators = regexp.MustCompile(`(?i)\s*[;&+]\s*|\s+(?:and|en|und|et|with|met)\s+`)
+
+// particles are the words in front of a last name that sort after the
+// first name, like "van den" in "Lizzie van den Ham"
+var particles = map[string]bool{
+	"van": true, "von": true, "de": true, "der": true, "den": true, "het": true,
+	"ten": true, "ter": true, "te": true, "'t": true, "du": true, "des": true,
+	"le": true, "la": true, "di": true, "da": true, "del": true, "dos": true,
+	"zu": true, "op": true, "in": true,
+}
+
+// SplitAuthors splits s into the names of all authors, "A and B", "A & B"
+// and "A; B" are all split. A comma only separates authors when there are
+// more than two parts that all look like a full name, a single comma is read
+// as "Last, First" because names like "Le Guin, Ursula K." can't be told
+// apart from two authors.
+func SplitAuthors(s string) []string {
+	var authors []string
+	for _, part := range authorSeparators.Split(s, -1) {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		names := strings.Split(part, ",")
+		full := len(names) > 2
+		for _, n := range names {
+			if len(strings.Fields(normalizeInitials(n))) < 2 {
+				full = false
+			}
+		}
+		if full {
+			authors = append(authors, names...)
+		} else {
+			authors = append(authors, part)
+		}
+	}
+
+	var fixed []string
+	seen := make(map[string]bool)
+	for _, a := range authors {
+		a = FixAuthor(a)
+		if IsPlaceholder(a) || seen[AuthorKey(a)] {
+			continue
+		}
+		seen[AuthorKey(a)] = true
+		fixed = append(fixed, a)
+	}
+	return fixed
+}
+
+// FixAuthor cleans up a single name, "Last, First" is turned around,
+// initials are written as "J.R.R." and particles like "van" are lowercase.
+// A particle that is capitalized in front of the last name, like in "Le
+// Guin, Ursula K.", is part of the last name and keeps its case.
+func FixAuthor(s string) string {
+	s = strings.TrimSpace(s)
+	var lastName []string
+	if parts := strings.Split(s, ","); len(parts) == 2 {
+		lastName = strings.Fields(parts[0])
+		s = strings.TrimSpace(parts[1]) + " " + strings.TrimSpace(parts[0])
+	}
+	s = strings.Replace(s, ",", " ", -1)
+	s = yearRemove.ReplaceAllString(s, "")
+	s = normalizeInitials(s)
+	if s == "" {
+		return ""
+	}
+	s = Fix(s, false, false)
+	s = NameCase(normalizeInitials(s))
+
+	words := strings.Fields(s)
+	offset := len(words) - len(lastName)
+	for i, w := range lastName {
+		if offset < 0 || !particles[strings.ToLower(w)] || !unicode.IsUpper([]rune(w)[0]) {
+			break
+		}
+		words[offset+i] = capitalize(words[offset+i])
+	}
+	return strings.Join(words, " ")
+}
+
+// normalizeInitials writes consecutive initials as a single word like
+// "J.R.R.", so "J. R. R. Tolkien", "J R R Tolkien" and "J.R.R. Tolkien" are
+// all the same
+func normalizeInitials(s string) string {
+	var words []string
+	initials := ""
+	for _, w := range strings.Fields(strings.Replace(s, ".", ". ", -1)) {
+		letters := strings.TrimSuffix(w, ".")
+		if len([]rune(letters)) == 1 && !particles[strings.ToLower(letters)] {
+			initials += strings.ToUpper(letters) + "."
+			continue
+		}
+		if initials != "" {
+			words = append(words, initials)
+			initials = ""
+		}
+		words = append(words, w)
+	}
+	if initials != "" {
+		words = append(words, initials)
+	}
+	return strings.Join(words, " ")
+}
+
+// AuthorKey returns the key used to find the canonical author of a name,
+// names that only differ in accents, case or punctuation share a key
+func AuthorKey(name string) string {
+	var words []string
+	initials := ""
+	for _, w := range strings.Fields(Normalize(name)) {
+		if len(w) == 1 {
+			initials += w
+			continue
+		}
+		if initials != "" {
+			words = append(words, initials)
+			initials = ""
+		}
+		words = append(words, w)
+	}
+	if initials != "" {
+		words = append(words, initials)
+	}
+	return strings.Join(words, " ")
+}
+
+// AuthorSort returns the name of the author in "Last, First" form, particles
+// like "van den" are placed after the first name. Capitalized particles like
+// in "Ursula K. Le Guin" are part of the last name.
+func AuthorSort(author string) string {
+	parts := strings.Fields(author)
+	if len(parts) < 2 {
+		return strings.Join(parts, " ")
+	}
+	last := len(parts) - 1
+	for last > 1 && particles[strings.ToLower(parts[last-1])] && !particles[parts[last-1]] {
+		last--
+	}
+	start := last
+	for start > 1 && particles[parts[start-1]] {
+		start--
+	}
+	sort := strings.Join(parts[last:], " ") + ", " + strings.Join(parts[:start], " ")
+	if start < last {
+		sort += " " + strings.Join(parts[start:last], " ")
+	}
+	return sort
+}
+
+// JoinAuthors returns the names of multiple authors as a single string
+func JoinAuthors(names []string) string {
+	return strings.Join(names, " & ")
+}
+
+// Letter returns the letter the author is listed under in the authors index,
+// names that don't start with a letter from A to Z are listed under "#"
+func (a Author) Letter() string {
+	name := Transliterate(a.SortName)
+	if name == "" {
+		name = Transliterate(a.Name)
+	}
+	if name == "" {
+		return "#"
+	}
+	l := strings.ToUpper(name[:1])
+	if l < "A" || l > "Z" {
+		return "#"
+	}
+	return l
+}
+
+// SeriesGroup holds the books of a single series, books without a series are
+// grouped with an empty Series
+type SeriesGroup struct {
+	Series string
+	Books  []Book
+}
+
+// GroupBySeries groups books by series, the books in a series are sorted by
+// their index and then by date. Series are listed by the date of their first
+// book, books without a series come last, sorted by date.
+func GroupBySeries(books []Book) []SeriesGroup {
+	sorted := make([]Book, len(books))
+	copy(sorted, books)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Added.Before(sorted[j].Added)
+	})
+
+	var groups []SeriesGroup
+	var standalone []Book
+	index := make(map[string]int)
+	for _, b := range sorted {
+		if b.Series == "" {
+			standalone = append(standalone, b)
+			continue
+		}
+		key := strings.ToLower(b.Series)
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, SeriesGroup{Series: b.Series})
+		}
+		groups[i].Books = append(groups[i].Books, b)
+	}
+
+	for _, g := range groups {
+		sort.SliceStable(g.Books, func(i, j int) bool {
+			return g.Books[i].SeriesIndex < g.Books[j].SeriesIndex
+		})
+	}
+	if len(standalone) > 0 {
+		groups = append(groups, SeriesGroup{Books: standalone})
+	}
+	return groups
+}
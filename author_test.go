@@ -0,0 +1,112 @@
+package booksing
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_splitAuthors(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"J.R.R. Tolkien", []string{"J.R.R. Tolkien"}},
+		{"Tolkien, J. R. R.", []string{"J.R.R. Tolkien"}},
+		{"brown, dan", []string{"Dan Brown"}},
+		{"Terry Pratchett & Neil Gaiman", []string{"Terry Pratchett", "Neil Gaiman"}},
+		{"Terry Pratchett and Neil Gaiman", []string{"Terry Pratchett", "Neil Gaiman"}},
+		{"Pratchett, Terry; Gaiman, Neil", []string{"Terry Pratchett", "Neil Gaiman"}},
+		{"Terry Pratchett, Neil Gaiman, Stephen Baxter", []string{"Terry Pratchett", "Neil Gaiman", "Stephen Baxter"}},
+		{"Le Guin, Ursula K.", []string{"Ursula K. Le Guin"}},
+		{"LE GUIN, URSULA K.", []string{"Ursula K. Le Guin"}},
+		{"García Márquez, Gabriel José", []string{"Gabriel José García Márquez"}},
+		{"van den Ham, Lizzie", []string{"Lizzie van den Ham"}},
+		{"Nicci French en Nicci French", []string{"Nicci French"}},
+		{"Unknown", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := SplitAuthors(tt.in); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SplitAuthors() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_authorKey(t *testing.T) {
+	same := [][2]string{
+		{"J.R.R. Tolkien", "J R R Tolkien"},
+		{"Håkan Östlundh", "Hakan Ostlundh"},
+	}
+	for _, names := range same {
+		if AuthorKey(names[0]) != AuthorKey(names[1]) {
+			t.Errorf("AuthorKey(%s) = %s, AuthorKey(%s) = %s, want the same", names[0], AuthorKey(names[0]), names[1], AuthorKey(names[1]))
+		}
+	}
+}
+
+func Test_authorSort(t *testing.T) {
+	tests := map[string]string{
+		"Dan Brown":            "Brown, Dan",
+		"Lizzie van den Ham":   "Ham, Lizzie van den",
+		"J.R.R. Tolkien":       "Tolkien, J.R.R.",
+		"Ludwig van Beethoven": "Beethoven, Ludwig van",
+		"Ursula K. Le Guin":    "Le Guin, Ursula K.",
+		"Plato":                "Plato",
+	}
+	for in, want := range tests {
+		if got := AuthorSort(in); got != want {
+			t.Errorf("AuthorSort(%s) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func Test_authorLetter(t *testing.T) {
+	tests := []struct {
+		sortName string
+		want     string
+	}{
+		{"Tolkien, J.R.R.", "T"},
+		{"Östlundh, Håkan", "O"},
+		{"ham, Lizzie van den", "H"},
+		{"1984 Collective", "#"},
+		{"", "#"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sortName, func(t *testing.T) {
+			if got := (Author{SortName: tt.sortName}).Letter(); got != tt.want {
+				t.Errorf("Letter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_groupBySeries(t *testing.T) {
+	day := func(d int) time.Time {
+		return time.Date(2020, 1, d, 0, 0, 0, 0, time.UTC)
+	}
+	books := []Book{
+		{Title: "Standalone", Added: day(1)},
+		{Title: "Riyria 2", Series: "Riyria", SeriesIndex: 2, Added: day(2)},
+		{Title: "Sullivan 1", Series: "Sullivan", SeriesIndex: 1, Added: day(3)},
+		{Title: "Riyria 1", Series: "riyria", SeriesIndex: 1, Added: day(4)},
+		{Title: "Later", Added: day(5)},
+	}
+	var got [][]string
+	for _, g := range GroupBySeries(books) {
+		titles := []string{g.Series}
+		for _, b := range g.Books {
+			titles = append(titles, b.Title)
+		}
+		got = append(got, titles)
+	}
+	want := [][]string{
+		{"Riyria", "Riyria 1", "Riyria 2"},
+		{"Sullivan", "Sullivan 1"},
+		{"", "Standalone", "Later"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GroupBySeries() = %q, want %q", got, want)
+	}
+}
@@ -1,11 +1,9 @@
 package booksing
 
 import (
-	"fmt"
 	"os"
-	"path"
-	"path/filepath"
 	"regexp"
+	"strconv"
 	"time"
 
 	"strings"
@@ -16,6 +14,7 @@ import (
 
 var yearRemove = regexp.MustCompile(`\((1|2)[0-9]{3}\)`)
 var drukRemove = regexp.MustCompile(`(?i)/ druk [0-9]+`)
+var seriesNumber = regexp.MustCompile(`^\[?(.*?)\]?\s*#\s*([0-9]+(\.[0-9]+)?)$`)
 var filenameSafe = regexp.MustCompile("[^a-zA-Z0-9 -]+")
 
 type StorageLocation string
@@ -29,10 +28,25 @@ type Book struct {
 	Hash        string `storm:"id"`
 	Title       string
 	Author      string
+	Authors     []string
+	AuthorIDs   []string
 	Language    string
 	Description string
+	Identifiers []string
+	Series      string
+	SeriesIndex float64
+	Sources     map[string]MetadataSource
 	Added       time.Time `storm:"index"`
+	// Imported is when the book was added to the library, Added is the
+	// modification time of its file
+	Imported    time.Time
 	Path        string
+	Size        int64
+	Checksum    string
+	EpubVersion string
+	HasCover    bool
+	Valid       bool
+	Files       []BookFile
 	Icon        ShelveIcon
 }
 
@@ -46,9 +60,9 @@ type BookInput struct {
 
 func (b *BookInput) ToBook() Book {
 	var book Book
-	book.Author = Fix(b.Author, true, true)
-	book.Title = Fix(b.Title, true, false)
+	book.SetAuthor(b.Author)
 	book.Language = FixLang(b.Language)
+	book.Title = FixTitle(b.Title, book.Language, false)
 	book.Description = b.Description
 	book.Path = b.Path
 
@@ -62,8 +76,15 @@ type FileLocation struct {
 	Path string
 }
 
-// NewBookFromFile creates a book object from a file
-func NewBookFromFile(bookpath string, baseDir string) (bk *Book, err error) {
+// ImportOptions control how the metadata of a new book is cleaned up
+type ImportOptions struct {
+	FilenamePatterns []FilenamePattern
+	PreferFilename   bool
+	KeepCasing       bool
+}
+
+// NewBookFromFile creates a book object from a file, the file is left in place
+func NewBookFromFile(bookpath string, opts ImportOptions) (bk *Book, err error) {
 	epub, err := epub.ParseFile(bookpath)
 	if err != nil {
 		return nil, err
@@ -74,62 +95,140 @@ func NewBookFromFile(bookpath string, baseDir string) (bk *Book, err error) {
 		Author:      epub.Author,
 		Language:    epub.Language,
 		Description: epub.Description,
+		Identifiers: epub.Identifiers,
+		EpubVersion: epub.Version,
+		HasCover:    epub.HasCover,
+		Valid:       epub.Valid,
+		Path:        bookpath,
 	}
 
-	f, err := os.Open(bookpath)
+	fi, err := os.Stat(bookpath)
 	if err != nil {
 		return nil, err
 	}
+	book.Added = fi.ModTime()
+	book.Size = fi.Size()
 
-	fp := bookpath
-
-	fi, err := f.Stat()
+	book.Checksum, err = FileChecksum(bookpath)
 	if err != nil {
-		f.Close()
 		return nil, err
 	}
-	book.Added = fi.ModTime()
 
-	book.Title = Fix(book.Title, true, false)
-	book.Author = Fix(book.Author, true, true)
 	book.Language = FixLang(book.Language)
+	book.Title = FixTitle(book.Title, book.Language, opts.KeepCasing)
+	book.SetAuthor(epub.Author)
 	book.Description = sanitize.HTML(book.Description)
+	book.Series, book.SeriesIndex = FixSeries(epub.Series, epub.SeriesIndex)
+
+	book.Sources = make(map[string]MetadataSource)
+	if !IsPlaceholder(epub.Title) {
+		book.Sources["title"] = SourceOPF
+	}
+	if !IsPlaceholder(epub.Author) {
+		book.Sources["author"] = SourceOPF
+	}
+	if book.Series != "" {
+		book.Sources["series"] = SourceOPF
+	}
+	book.ApplyFilename(bookpath, opts)
 
 	book.Hash = HashBook(book.Author, book.Title)
 
-	newBookPath := path.Join(baseDir, GetBookPath(book.Title, book.Author)+".epub")
-	baseDir = filepath.Dir(newBookPath)
-	err = os.MkdirAll(baseDir, 0755)
-	if err == nil {
-		_ = os.Rename(bookpath, newBookPath)
-		fp = newBookPath
+	return &book, nil
+}
+
+// Reparse takes the metadata of the book from parsed, a new parse of its file.
+// Fields that were set by hand are kept.
+func (b *Book) Reparse(parsed *Book) {
+	if b.Sources == nil {
+		b.Sources = make(map[string]MetadataSource)
+	}
+	fields := []struct {
+		name string
+		copy func()
+	}{
+		{"title", func() { b.Title = parsed.Title }},
+		{"author", func() { b.Author, b.Authors, b.AuthorIDs = parsed.Author, parsed.Authors, nil }},
+		{"series", func() { b.Series, b.SeriesIndex = parsed.Series, parsed.SeriesIndex }},
+		{"language", func() { b.Language = parsed.Language }},
+		{"description", func() { b.Description = parsed.Description }},
+	}
+	for _, f := range fields {
+		if b.Sources[f.name] == SourceManual {
+			continue
+		}
+		f.copy()
+		if source, ok := parsed.Sources[f.name]; ok {
+			b.Sources[f.name] = source
+		} else {
+			delete(b.Sources, f.name)
+		}
 	}
-	book.Path = fp
 
-	return &book, nil
+	b.Identifiers = parsed.Identifiers
+	b.EpubVersion = parsed.EpubVersion
+	b.HasCover = parsed.HasCover
+	b.Valid = parsed.Valid
+	b.Size = parsed.Size
+	b.Checksum = parsed.Checksum
 }
 
+// SetAuthor splits s into the names of all authors of the book
+func (b *Book) SetAuthor(s string) {
+	b.Authors = SplitAuthors(s)
+	b.AuthorIDs = nil
+	if len(b.Authors) == 0 {
+		b.Author = Fix("", true, true)
+		return
+	}
+	b.Author = JoinAuthors(b.Authors)
+}
+
+// Move moves the file of the book to its place in the library
+func (b *Book) Move(lib Library) error {
+	newBookPath := lib.Path(b, 0, b.PrimaryFile())
+	if newBookPath == b.Path {
+		return nil
+	}
+	newBookPath, err := MoveFile(b.Path, newBookPath)
+	if err != nil {
+		return err
+	}
+	b.Path = newBookPath
+	return nil
+}
+
+// GetBookPath returns the location of a book with the default template
 func GetBookPath(title, author string) string {
-	author = filenameSafe.ReplaceAllString(author, "")
-	title = filenameSafe.ReplaceAllString(title, "")
-	if len(title) > 35 {
-		title = title[:30]
-	}
-	title = strings.TrimSpace(title)
-	author = strings.TrimSpace(author)
-	if len(author) == 0 {
-		author = "unknown"
-	}
-	if len(title) == 0 {
-		author = "unknown"
-	}
-	parts := strings.Split(author, " ")
-	firstChar := parts[len(parts)-1][0:1]
-	formatted := fmt.Sprintf("%s/%s/%s-%s", firstChar, author, author, title)
-	formatted = strings.Replace(formatted, " ", "_", -1)
-	formatted = strings.Replace(formatted, "__", "_", -1)
-
-	return formatted
+	t, _ := ParsePathTemplate(DefaultPathTemplate)
+	return t.Render(&Book{Title: title, Author: author}, "epub")
+}
+
+// FixSeries cleans up the series name and parses the series index, a number
+// in the name like "[Sullivan] #2" takes precedence over the given index
+func FixSeries(name, index string) (string, float64) {
+	name = strings.TrimSpace(name)
+	i, _ := strconv.ParseFloat(strings.TrimSpace(index), 64)
+
+	if m := seriesNumber.FindStringSubmatch(name); m != nil {
+		name = strings.TrimSpace(m[1])
+		i, _ = strconv.ParseFloat(m[2], 64)
+	}
+	name = strings.Trim(name, "[]")
+	if name == "" {
+		return "", 0
+	}
+	return name, i
+}
+
+// FixTitle cleans up a title and capitalizes it following the rules of lang,
+// with keepCasing a title that already has deliberate casing is left as is
+func FixTitle(s, lang string, keepCasing bool) string {
+	s = Fix(s, false, false)
+	if keepCasing && LooksCased(s) {
+		return s
+	}
+	return TitleCase(s, lang)
 }
 
 func FixLang(s string) string {
@@ -197,8 +296,7 @@ func Fix(s string, capitalize, correctOrder bool) string {
 		return "Unknown"
 	}
 	if capitalize {
-		s = strings.Title(strings.ToLower(s))
-		s = strings.Replace(s, "'S", "'s", -1)
+		s = TitleCase(s, "")
 	}
 	if correctOrder && strings.Contains(s, ",") {
 		sParts := strings.Split(s, ",")
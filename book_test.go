@@ -58,3 +58,62 @@ func Test_fix(t *testing.T) {
 		})
 	}
 }
+
+func Test_fixSeries(t *testing.T) {
+	tests := []struct {
+		name, index string
+		want        string
+		wantIndex   float64
+	}{
+		{"De Cock", "2.0", "De Cock", 2},
+		{"[Sullivan] #1", "", "Sullivan", 1},
+		{"", "3", "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, index := FixSeries(tt.name, tt.index)
+			if got != tt.want || index != tt.wantIndex {
+				t.Errorf("FixSeries() = %v, %v, want %v, %v", got, index, tt.want, tt.wantIndex)
+			}
+		})
+	}
+}
+
+func Test_reparse(t *testing.T) {
+	b := Book{
+		Title:    "Mijn eigen titel",
+		Author:   "Onbekend",
+		Series:   "Oude serie",
+		Language: "nl",
+		Sources: map[string]MetadataSource{
+			"title":  SourceManual,
+			"author": SourceFilename,
+			"series": SourceOPF,
+		},
+	}
+	parsed := &Book{
+		Title:    "Een nieuw begin in Rose Harbor",
+		Author:   "Debbie Macomber",
+		Authors:  []string{"Debbie Macomber"},
+		Language: "nl",
+		HasCover: true,
+		Sources: map[string]MetadataSource{
+			"title":  SourceOPF,
+			"author": SourceOPF,
+		},
+	}
+	b.Reparse(parsed)
+
+	if b.Title != "Mijn eigen titel" || b.Sources["title"] != SourceManual {
+		t.Errorf("Reparse() changed the title that was set by hand to %q (%s)", b.Title, b.Sources["title"])
+	}
+	if b.Author != "Debbie Macomber" || b.Sources["author"] != SourceOPF {
+		t.Errorf("Reparse() author = %q (%s), want Debbie Macomber (opf)", b.Author, b.Sources["author"])
+	}
+	if _, ok := b.Sources["series"]; b.Series != "" || ok {
+		t.Errorf("Reparse() series = %q, want no series", b.Series)
+	}
+	if !b.HasCover {
+		t.Errorf("Reparse() did not take the cover from the file")
+	}
+}
@@ -0,0 +1,115 @@
+package booksing
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// BookFile is a file attached to a book next to its primary file, like a
+// different edition, translation or format of the same work
+type BookFile struct {
+	Path        string
+	Format      string
+	Title       string
+	Language    string
+	Size        int64
+	Checksum    string
+	EpubVersion string
+	HasCover    bool
+	Valid       bool
+	Added       time.Time
+}
+
+// PrimaryFile describes the primary file of the book
+func (b Book) PrimaryFile() BookFile {
+	return BookFile{
+		Path:        b.Path,
+		Format:      FileFormat(b.Path),
+		Title:       b.Title,
+		Language:    b.Language,
+		Size:        b.Size,
+		Checksum:    b.Checksum,
+		EpubVersion: b.EpubVersion,
+		HasCover:    b.HasCover,
+		Valid:       b.Valid,
+		Added:       b.Added,
+	}
+}
+
+// AllFiles returns the primary file followed by all attached files
+func (b Book) AllFiles() []BookFile {
+	return append([]BookFile{b.PrimaryFile()}, b.Files...)
+}
+
+// Attach moves the primary file of other next to the files of b in the
+// library and adds it as an attached file
+func (b *Book) Attach(other *Book, lib Library) error {
+	return b.AttachFile(other.PrimaryFile(), lib)
+}
+
+// AttachFile moves f next to the files of b in the library and adds it as
+// an attached file
+func (b *Book) AttachFile(f BookFile, lib Library) error {
+	newPath, err := MoveFile(f.Path, lib.Path(b, len(b.Files)+1, f))
+	if err != nil {
+		return err
+	}
+	f.Path = newPath
+	b.Files = append(b.Files, f)
+	return nil
+}
+
+// SameFile reports whether the file of other is a copy of one of the files
+// of b, files without a checksum are compared on size and format
+func (b Book) SameFile(other *Book) bool {
+	o := other.PrimaryFile()
+	for _, f := range b.AllFiles() {
+		if f.Checksum != "" && o.Checksum != "" {
+			if f.Checksum == o.Checksum {
+				return true
+			}
+			continue
+		}
+		if f.Size == o.Size && f.Format == o.Format {
+			return true
+		}
+	}
+	return false
+}
+
+// Checksums returns the checksums of all files of the book
+func (b Book) Checksums() []string {
+	var sums []string
+	for _, f := range b.AllFiles() {
+		if f.Checksum != "" {
+			sums = append(sums, f.Checksum)
+		}
+	}
+	return sums
+}
+
+// FileChecksum returns the hex encoded SHA-256 of the file at p
+func FileChecksum(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// FileFormat returns the format of a file based on its extension
+func FileFormat(p string) string {
+	return strings.ToLower(strings.TrimPrefix(filepath.Ext(p), "."))
+}
@@ -0,0 +1,156 @@
+package booksing
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var romanNumeral = regexp.MustCompile(`^(?i)(x{0,3})(ix|iv|v?i{0,3})$`)
+
+// smallWords are written in lowercase in titles unless they are the first word
+var smallWords = map[string]map[string]bool{
+	"en": set("a", "an", "the", "and", "but", "or", "nor", "for", "of", "on", "in", "at", "to", "by", "with", "from", "as"),
+	"nl": set("de", "het", "een", "en", "van", "in", "op", "met", "voor", "uit", "door", "bij", "naar", "over", "aan", "te", "of", "om", "tot", "der", "den", "'t"),
+	"de": set("der", "die", "das", "dem", "den", "des", "ein", "eine", "einer", "und", "oder", "von", "vom", "zu", "zum", "zur", "im", "in", "mit", "auf", "aus", "am", "an"),
+	"fr": set("le", "la", "les", "l'", "un", "une", "des", "de", "du", "d'", "et", "ou", "à", "au", "aux", "en", "sur", "par"),
+}
+
+func set(words ...string) map[string]bool {
+	m := make(map[string]bool)
+	for _, w := range words {
+		m[w] = true
+	}
+	return m
+}
+
+// LooksCased reports whether s has deliberate casing, it contains both upper
+// and lowercase letters and starts with an uppercase letter
+func LooksCased(s string) bool {
+	hasUpper, hasLower := false, false
+	first := true
+	for _, r := range s {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		if first && !unicode.IsUpper(r) {
+			return false
+		}
+		first = false
+		hasUpper = hasUpper || unicode.IsUpper(r)
+		hasLower = hasLower || unicode.IsLower(r)
+	}
+	return hasUpper && hasLower
+}
+
+// TitleCase capitalizes a title following the rules of its language. Small
+// words like articles stay lowercase, roman numerals are written in
+// uppercase and words like "FBI" or "iPhone" are kept as they are, unless the
+// whole title is written in capitals.
+func TitleCase(s, lang string) string {
+	lang = strings.ToLower(strings.SplitN(lang, "-", 2)[0])
+	shouting := strings.ToUpper(s) == s
+	small := smallWords[lang]
+
+	words := strings.Fields(s)
+	for i, w := range words {
+		lower := strings.ToLower(w)
+		switch {
+		case !shouting && hasInnerCapital(w):
+		case isRomanNumeral(w):
+			words[i] = strings.ToUpper(w)
+		case i > 0 && small[strings.Trim(lower, ",.:;!?()\"")]:
+			words[i] = lower
+		case lang == "nl":
+			words[i] = capitalizeIJ(capitalize(lower))
+		default:
+			words[i] = capitalize(lower)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// NameCase capitalizes the name of a person, particles like "van der" and
+// "von" are lowercase unless they start the name. Names like "McEwan" are
+// kept as they are, unless the whole name is written in capitals.
+func NameCase(s string) string {
+	shouting := strings.ToUpper(s) == s
+	words := strings.Fields(s)
+	for i, w := range words {
+		lower := strings.ToLower(w)
+		switch {
+		case i > 0 && i < len(words)-1 && particles[lower]:
+			words[i] = lower
+			continue
+		case isInitials(w), i > 0 && isRomanNumeral(w):
+			words[i] = strings.ToUpper(w)
+			continue
+		case !shouting && hasInnerCapital(w) && strings.ToUpper(w) != w:
+			continue
+		}
+		parts := strings.Split(lower, "-")
+		for j, p := range parts {
+			p = capitalizeIJ(capitalize(p))
+			if strings.HasPrefix(p, "Mc") && len(p) > 2 {
+				p = "Mc" + capitalize(p[2:])
+			}
+			parts[j] = p
+		}
+		words[i] = strings.Join(parts, "-")
+	}
+	return strings.Join(words, " ")
+}
+
+// capitalize writes the first letter of s in uppercase, apostrophes and
+// other leading punctuation are skipped, "'t" stays lowercase
+func capitalize(s string) string {
+	if s == "'t" {
+		return s
+	}
+	runes := []rune(s)
+	for i, r := range runes {
+		if unicode.IsLetter(r) {
+			runes[i] = unicode.ToUpper(r)
+			break
+		}
+		if unicode.IsDigit(r) {
+			break
+		}
+	}
+	return string(runes)
+}
+
+// capitalizeIJ writes the Dutch digraph ij at the start of a word as IJ
+func capitalizeIJ(s string) string {
+	if strings.HasPrefix(s, "Ij") {
+		return "IJ" + s[2:]
+	}
+	return s
+}
+
+// hasInnerCapital reports whether w has a capital after its first letter,
+// like FBI, iPhone or McEwan
+func hasInnerCapital(w string) bool {
+	first := true
+	for _, r := range w {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		if !first && unicode.IsUpper(r) {
+			return true
+		}
+		first = false
+	}
+	return false
+}
+
+// isRomanNumeral reports whether w is a roman number up to 39, like II or XIV,
+// larger numbers are left alone as they look like words such as "mix"
+func isRomanNumeral(w string) bool {
+	return len(w) > 1 && romanNumeral.MatchString(w)
+}
+
+// isInitials reports whether w is a set of initials like J.R.R.
+func isInitials(w string) bool {
+	return strings.HasSuffix(w, ".") && len(strings.Replace(w, ".", "", -1))*2 == len(w)
+}
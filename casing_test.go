@@ -0,0 +1,68 @@
+package booksing
+
+import "testing"
+
+func Test_titleCase(t *testing.T) {
+	tests := []struct {
+		in, lang string
+		want     string
+	}{
+		{"DE COCK EN HET DODELIJK AKKOORD", "nl", "De Cock en het Dodelijk Akkoord"},
+		{"reis naar ijsland", "nl", "Reis naar IJsland"},
+		{"the lord of the rings", "en", "The Lord of the Rings"},
+		{"henry viii", "en", "Henry VIII"},
+		{"the FBI files", "en", "The FBI Files"},
+		{"DIE VERWANDLUNG UND ANDERE ERZÄHLUNGEN", "de", "Die Verwandlung und Andere Erzählungen"},
+		{"mix tape", "", "Mix Tape"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := TitleCase(tt.in, tt.lang); got != tt.want {
+				t.Errorf("TitleCase() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_nameCase(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"Lizzie Van Den Ham", "Lizzie van den Ham"},
+		{"LUDWIG VAN BEETHOVEN", "Ludwig van Beethoven"},
+		{"Van Gogh", "Van Gogh"},
+		{"ian mcewan", "Ian McEwan"},
+		{"Leonardo DiCaprio", "Leonardo DiCaprio"},
+		{"ijsbrand de vries", "IJsbrand de Vries"},
+		{"J.R.R. TOLKIEN", "J.R.R. Tolkien"},
+		{"Victor HUGO", "Victor Hugo"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := NameCase(tt.in); got != tt.want {
+				t.Errorf("NameCase() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_fixTitle(t *testing.T) {
+	tests := []struct {
+		in, lang   string
+		keepCasing bool
+		want       string
+	}{
+		{"De Cock en het dodelijk akkoord", "nl", true, "De Cock en het dodelijk akkoord"},
+		{"De Cock en het dodelijk akkoord", "nl", false, "De Cock en het Dodelijk Akkoord"},
+		{"de cock en het dodelijk akkoord", "nl", true, "De Cock en het Dodelijk Akkoord"},
+		{"HARRY POTTER", "en", true, "Harry Potter"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := FixTitle(tt.in, tt.lang, tt.keepCasing); got != tt.want {
+				t.Errorf("FixTitle() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gnur/booksing"
+	"github.com/markbates/pkger"
+)
+
+const (
+	apiPrefix       = "/api/"
+	defaultAPILimit = 20
+	maxAPILimit     = 100
+	maxRelated      = 10
+)
+
+// apiErrorResponse is the body of every error the API returns
+type apiErrorResponse struct {
+	Status int    `json:"status"`
+	Error  string `json:"error"`
+}
+
+// apiSearchResult is a page of search results
+type apiSearchResult struct {
+	*booksing.SearchResult
+	Limit  int64
+	Offset int64
+	Sort   string
+}
+
+// apiShelf is a shelf with the number of books the user put on it
+type apiShelf struct {
+	Name  string
+	Icon  booksing.ShelveIcon
+	Books int
+}
+
+// apiBookmarkInput is the body to put a book on a shelf
+type apiBookmarkInput struct {
+	Shelf string
+}
+
+// apiUserInput is the body to change a user
+type apiUserInput struct {
+	IsAllowed bool
+}
+
+// apiStats is the number of books in the library over the last year
+type apiStats struct {
+	Books   int
+	History []booksing.BookCount
+	Index   booksing.IndexProgress
+}
+
+// apiError sends an error object with the status code
+func apiError(c *gin.Context, code int, err error) {
+	c.JSON(code, apiErrorResponse{
+		Status: code,
+		Error:  err.Error(),
+	})
+}
+
+// apiBook returns a book, a 404 error is sent when it doesn't exist
+func (app *booksingApp) apiBook(c *gin.Context) (*booksing.Book, bool) {
+	book, err := app.db.GetBook(c.Param("hash"))
+	if err == booksing.ErrNotFound {
+		apiError(c, 404, errors.New("Book not found"))
+		return nil, false
+	} else if err != nil {
+		apiError(c, 500, err)
+		return nil, false
+	}
+	return book, true
+}
+
+// hidePaths removes where the files of books are stored unless the user is
+// an admin
+func hidePaths(c *gin.Context, books ...*booksing.Book) {
+	if c.GetBool("isAdmin") {
+		return
+	}
+	for _, b := range books {
+		b.Path = ""
+		for i := range b.Files {
+			b.Files[i].Path = ""
+		}
+	}
+}
+
+func hideListPaths(c *gin.Context, books []booksing.Book) {
+	for i := range books {
+		hidePaths(c, &books[i])
+	}
+}
+
+// queryInt parses an integer query parameter, def is used when it is missing
+func queryInt(c *gin.Context, name string, def int64) (int64, error) {
+	s := c.Query(name)
+	if s == "" {
+		return def, nil
+	}
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("%s must be a positive number", name)
+	}
+	return i, nil
+}
+
+func (app *booksingApp) apiSearch(c *gin.Context) {
+	user := c.MustGet("id").(*booksing.User)
+
+	limit, err := queryInt(c, "l", defaultAPILimit)
+	if err == nil && (limit == 0 || limit > maxAPILimit) {
+		err = fmt.Errorf("l must be between 1 and %d", maxAPILimit)
+	}
+	var offset int64
+	if err == nil {
+		offset, err = queryInt(c, "o", 0)
+	}
+	var query *booksing.Query
+	if err == nil {
+		query, err = userQuery(c.Query("q"), user)
+	}
+	if err == nil {
+		err = query.SetSort(c.Query("s"))
+	}
+	if err != nil {
+		apiError(c, 400, err)
+		return
+	}
+
+	res, err := app.db.GetBooks(query, limit, offset)
+	if err != nil {
+		apiError(c, 500, err)
+		return
+	}
+	user.SetIcons(res.Items)
+	hideListPaths(c, res.Items)
+
+	c.JSON(200, apiSearchResult{
+		SearchResult: res,
+		Limit:        limit,
+		Offset:       offset,
+		Sort:         query.Sort,
+	})
+}
+
+func (app *booksingApp) apiShowBook(c *gin.Context) {
+	user := c.MustGet("id").(*booksing.User)
+
+	book, ok := app.apiBook(c)
+	if !ok {
+		return
+	}
+	view := app.newBookView(book, user)
+	hidePaths(c, view.Book)
+	hideListPaths(c, view.SeriesBooks)
+	hideListPaths(c, view.AuthorBooks)
+	hideListPaths(c, view.Related)
+	c.JSON(200, view)
+}
+
+// apiRelatedBooks returns books that are like a book, books the user has
+// read are left out
+func (app *booksingApp) apiRelatedBooks(c *gin.Context) {
+	user := c.MustGet("id").(*booksing.User)
+
+	read, _ := booksing.ParseShelf("read")
+	books, err := app.db.GetRelatedBooks(c.Param("hash"), user.Shelf(read), maxRelated)
+	if err == booksing.ErrNotFound {
+		apiError(c, 404, errors.New("Book not found"))
+		return
+	} else if err != nil {
+		apiError(c, 500, err)
+		return
+	}
+	user.SetIcons(books)
+	hideListPaths(c, books)
+	c.JSON(200, books)
+}
+
+func (app *booksingApp) apiDownload(c *gin.Context) {
+	book, ok := app.apiBook(c)
+	if !ok {
+		return
+	}
+	app.sendBookFile(c, book)
+}
+
+func (app *booksingApp) apiShelves(c *gin.Context) {
+	user := c.MustGet("id").(*booksing.User)
+
+	shelves := []apiShelf{}
+	for _, name := range booksing.ShelfNames() {
+		icon, _ := booksing.ParseShelf(name)
+		shelves = append(shelves, apiShelf{
+			Name:  name,
+			Icon:  icon,
+			Books: len(user.Shelf(icon)),
+		})
+	}
+	c.JSON(200, shelves)
+}
+
+// apiBookmarks returns the books the user bookmarked, the shelf query
+// parameter only returns the books on that shelf
+func (app *booksingApp) apiBookmarks(c *gin.Context) {
+	user := c.MustGet("id").(*booksing.User)
+
+	var hashes []string
+	if shelf := c.Query("shelf"); shelf != "" {
+		icon, err := booksing.ParseShelf(shelf)
+		if err != nil {
+			apiError(c, 400, err)
+			return
+		}
+		hashes = user.Shelf(icon)
+	} else {
+		for hash := range user.Bookmarks {
+			hashes = append(hashes, hash)
+		}
+	}
+
+	books, err := app.db.GetBooksByHash(hashes)
+	if err != nil {
+		apiError(c, 500, err)
+		return
+	}
+	if books == nil {
+		books = []booksing.Book{}
+	}
+	user.SetIcons(books)
+	hideListPaths(c, books)
+	c.JSON(200, books)
+}
+
+func (app *booksingApp) apiSetBookmark(c *gin.Context) {
+	user := c.MustGet("id").(*booksing.User)
+
+	var input apiBookmarkInput
+	err := c.ShouldBindJSON(&input)
+	if err != nil {
+		apiError(c, 400, fmt.Errorf("Unable to read bookmark: %w", err))
+		return
+	}
+	icon, err := booksing.ParseShelf(input.Shelf)
+	if err != nil {
+		apiError(c, 400, err)
+		return
+	}
+	book, ok := app.apiBook(c)
+	if !ok {
+		return
+	}
+
+	user.SetShelf(book.Hash, icon)
+	err = app.db.SaveUser(user)
+	if err != nil {
+		apiError(c, 500, err)
+		return
+	}
+	book.Icon = user.Icon(book.Hash)
+	hidePaths(c, book)
+	c.JSON(200, book)
+}
+
+func (app *booksingApp) apiDeleteBookmark(c *gin.Context) {
+	user := c.MustGet("id").(*booksing.User)
+
+	user.SetShelf(c.Param("hash"), booksing.DefaultShelveIcon())
+	err := app.db.SaveUser(user)
+	if err != nil {
+		apiError(c, 500, err)
+		return
+	}
+	c.Status(204)
+}
+
+func (app *booksingApp) apiCurrentUser(c *gin.Context) {
+	c.JSON(200, c.MustGet("id"))
+}
+
+func (app *booksingApp) apiUsers(c *gin.Context) {
+	users, err := app.db.GetUsers()
+	if err != nil {
+		apiError(c, 500, err)
+		return
+	}
+	c.JSON(200, users)
+}
+
+// apiUpdateUser allows or denies a user access to booksing
+func (app *booksingApp) apiUpdateUser(c *gin.Context) {
+	var input apiUserInput
+	err := c.ShouldBindJSON(&input)
+	if err != nil {
+		apiError(c, 400, fmt.Errorf("Unable to read user: %w", err))
+		return
+	}
+
+	user, err := app.db.GetUser(c.Param("username"))
+	if err == booksing.ErrNotFound {
+		apiError(c, 404, errors.New("User not found"))
+		return
+	} else if err != nil {
+		apiError(c, 500, err)
+		return
+	}
+	user.IsAllowed = input.IsAllowed
+	err = app.db.SaveUser(&user)
+	if err != nil {
+		apiError(c, 500, err)
+		return
+	}
+	c.JSON(200, user)
+}
+
+func (app *booksingApp) apiStats(c *gin.Context) {
+	end := time.Now()
+	start := end.Add(-365 * 24 * time.Hour)
+
+	history, err := app.db.GetBookCountHistory(start, end)
+	if err != nil {
+		apiError(c, 500, err)
+		return
+	}
+	c.JSON(200, apiStats{
+		Books:   app.db.GetBookCount(),
+		History: history,
+		Index:   app.db.GetIndexProgress(),
+	})
+}
+
+func (app *booksingApp) apiDownloads(c *gin.Context) {
+	limit, err := queryInt(c, "l", maxAPILimit)
+	if err != nil {
+		apiError(c, 400, err)
+		return
+	}
+	dls, err := app.db.GetDownloads(int(limit))
+	if err != nil {
+		apiError(c, 500, err)
+		return
+	}
+	c.JSON(200, dls)
+}
+
+// serveOpenAPI returns the OpenAPI document that describes the API
+func serveOpenAPI(c *gin.Context) {
+	f, err := pkger.Open("/cmd/ui/static/openapi.json")
+	if err != nil {
+		apiError(c, 500, err)
+		return
+	}
+	defer f.Close()
+	doc, err := ioutil.ReadAll(f)
+	if err != nil {
+		apiError(c, 500, err)
+		return
+	}
+	c.Data(200, "application/json", doc)
+}
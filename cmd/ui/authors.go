@@ -0,0 +1,428 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gnur/booksing"
+	"github.com/sirupsen/logrus"
+)
+
+// authorsVersion is increased when the way books are linked to authors
+// changes, all books are linked again when the library is older
+const (
+	authorsVersion        = 2
+	authorsVersionSetting = "authorsversion"
+)
+
+var (
+	authorLocker = stateUnlocked
+)
+
+// aliasView holds an alias with the name of the author it points to
+type aliasView struct {
+	Alias  booksing.AuthorAlias
+	Author string
+}
+
+// authorView holds an author with the number of books by that author
+type authorView struct {
+	Author booksing.Author
+	Books  int
+}
+
+// letterView holds a letter of the authors index with the number of authors
+type letterView struct {
+	Letter  string
+	Authors int
+}
+
+// indexLetters are the letters of the authors index in order
+const indexLetters = "ABCDEFGHIJKLMNOPQRSTUVWXYZ#"
+
+// linkAuthors points the book to the canonical authors of all its names
+func (app *booksingApp) linkAuthors(b *booksing.Book) error {
+	names := b.Authors
+	if len(names) == 0 {
+		names = booksing.SplitAuthors(b.Author)
+	}
+
+	var ids, canonical []string
+	seen := make(map[string]bool)
+	for _, name := range names {
+		author, err := app.canonicalAuthor(name)
+		if err != nil {
+			return err
+		}
+		if seen[author.ID] {
+			continue
+		}
+		seen[author.ID] = true
+		ids = append(ids, author.ID)
+		canonical = append(canonical, author.Name)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	b.AuthorIDs = ids
+	b.Authors = canonical
+	b.Author = booksing.JoinAuthors(canonical)
+	return nil
+}
+
+// canonicalAuthor returns the author a name belongs to, following aliases,
+// the author is created when it doesn't exist yet
+func (app *booksingApp) canonicalAuthor(name string) (*booksing.Author, error) {
+	id := booksing.AuthorKey(name)
+	alias, err := app.db.GetAuthorAlias(id)
+	if err == nil {
+		id = alias.AuthorID
+	} else if err != booksing.ErrNotFound {
+		return nil, fmt.Errorf("Unable to get alias: %w", err)
+	}
+
+	author, err := app.db.GetAuthor(id)
+	if err == booksing.ErrNotFound {
+		author = &booksing.Author{
+			ID:       id,
+			Name:     name,
+			SortName: booksing.AuthorSort(name),
+		}
+		err = app.db.SaveAuthor(author)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get author: %w", err)
+	}
+	return author, nil
+}
+
+// relinkAuthorsIfNeeded links all books to their authors when the library
+// was linked by an older version
+func (app *booksingApp) relinkAuthorsIfNeeded() {
+	var version int
+	err := app.db.GetSetting(authorsVersionSetting, &version)
+	if err != nil && err != booksing.ErrNotFound {
+		app.logger.WithError(err).Error("could not get authors version")
+		return
+	}
+	if version >= authorsVersion {
+		return
+	}
+	err = app.relinkAuthors()
+	if err != nil {
+		app.logger.WithError(err).Error("linking books to authors failed")
+	}
+}
+
+// relinkAuthors links every book to its canonical authors again and removes
+// authors that no longer have any books, when the library was linked by an
+// older version every book is stored again to update the links. Books that
+// get a new hash are migrated like the rehash command does.
+func (app *booksingApp) relinkAuthors() error {
+	if !atomic.CompareAndSwapUint32(&authorLocker, stateUnlocked, stateLocked) {
+		return errors.New("authors are already being linked")
+	}
+	defer atomic.StoreUint32(&authorLocker, stateUnlocked)
+
+	var version int
+	err := app.db.GetSetting(authorsVersionSetting, &version)
+	if err != nil && err != booksing.ErrNotFound {
+		return fmt.Errorf("Unable to get authors version: %w", err)
+	}
+	outdated := version < authorsVersion
+
+	books, err := app.db.GetAllBooks()
+	if err != nil {
+		return fmt.Errorf("Unable to get books from db: %w", err)
+	}
+
+	used := make(map[string]bool)
+	relinked := 0
+	for i := range books {
+		b := &books[i]
+		before := b.Author + "|" + strings.Join(b.AuthorIDs, ",")
+		err = app.linkAuthors(b)
+		if err != nil {
+			return err
+		}
+		for _, id := range b.AuthorIDs {
+			used[id] = true
+		}
+		if !outdated && b.Author+"|"+strings.Join(b.AuthorIDs, ",") == before {
+			continue
+		}
+		err = app.db.AddBooks([]booksing.Book{*b}, true)
+		if err != nil {
+			return fmt.Errorf("Unable to store book: %w", err)
+		}
+		relinked++
+	}
+
+	// books are hashed on their canonical authors when they are imported, so
+	// books whose authors were renamed get the hash a new import would get
+	plan, err := app.planRehash(app.hasher)
+	if err != nil {
+		return err
+	}
+	if plan.changed > 0 {
+		err = app.applyRehash(plan, app.hasher)
+		if err != nil {
+			return fmt.Errorf("Unable to rehash renamed books: %w", err)
+		}
+	}
+
+	authors, err := app.db.GetAuthors()
+	if err != nil {
+		return fmt.Errorf("Unable to get authors from db: %w", err)
+	}
+	removed := 0
+	for _, a := range authors {
+		if used[a.ID] {
+			continue
+		}
+		err = app.db.DeleteAuthor(a.ID)
+		if err != nil {
+			return fmt.Errorf("Unable to delete author: %w", err)
+		}
+		removed++
+	}
+
+	err = app.db.SaveSetting(authorsVersionSetting, authorsVersion)
+	if err != nil {
+		return fmt.Errorf("Unable to store authors version: %w", err)
+	}
+
+	app.logger.WithFields(logrus.Fields{
+		"books":    len(books),
+		"relinked": relinked,
+		"removed":  removed,
+	}).Info("linked books to authors")
+	return nil
+}
+
+func (app *booksingApp) showAuthorAliases(c *gin.Context) {
+	aliases, err := app.db.GetAuthorAliases()
+	if err != nil {
+		c.HTML(500, "error.html", V{
+			Error: err,
+		})
+		return
+	}
+
+	var views []aliasView
+	for _, a := range aliases {
+		view := aliasView{
+			Alias:  a,
+			Author: a.AuthorID,
+		}
+		if author, err := app.db.GetAuthor(a.AuthorID); err == nil {
+			view.Author = author.Name
+		}
+		views = append(views, view)
+	}
+
+	c.HTML(200, "aliases.html", V{
+		Q:          "",
+		IsAdmin:    c.GetBool("isAdmin"),
+		TotalBooks: app.db.GetBookCount(),
+		Aliases:    views,
+		Checking:   atomic.LoadUint32(&authorLocker) == stateLocked,
+		Indexing:   app.state == "indexing",
+	})
+}
+
+// addAuthorAlias makes a variant of a name point to the canonical author,
+// all books are linked again so books by the variant move to that author
+func (app *booksingApp) addAuthorAlias(c *gin.Context) {
+	variant := booksing.FixAuthor(c.PostForm("alias"))
+	name := booksing.FixAuthor(c.PostForm("author"))
+	if variant == "" || name == "" {
+		c.HTML(400, "error.html", V{
+			Error: errors.New("Both the alias and the author are required"),
+		})
+		return
+	}
+	if booksing.AuthorKey(variant) == booksing.AuthorKey(name) {
+		c.HTML(400, "error.html", V{
+			Error: errors.New("The alias is already the same as the author"),
+		})
+		return
+	}
+
+	author, err := app.canonicalAuthor(name)
+	if err != nil {
+		c.HTML(500, "error.html", V{
+			Error: err,
+		})
+		return
+	}
+	if author.ID == booksing.AuthorKey(variant) {
+		c.HTML(400, "error.html", V{
+			Error: fmt.Errorf("%s is an alias of %s already", name, variant),
+		})
+		return
+	}
+
+	err = app.db.SaveAuthorAlias(&booksing.AuthorAlias{
+		ID:       booksing.AuthorKey(variant),
+		Name:     variant,
+		AuthorID: author.ID,
+	})
+	if err != nil {
+		c.HTML(500, "error.html", V{
+			Error: err,
+		})
+		return
+	}
+
+	// aliases of the variant now point to the author as well
+	aliases, err := app.db.GetAuthorAliases()
+	if err == nil {
+		for _, a := range aliases {
+			if a.AuthorID != booksing.AuthorKey(variant) {
+				continue
+			}
+			a.AuthorID = author.ID
+			err = app.db.SaveAuthorAlias(&a)
+			if err != nil {
+				app.logger.WithError(err).Error("could not update alias")
+			}
+		}
+	}
+
+	app.logger.WithFields(logrus.Fields{
+		"alias":  variant,
+		"author": author.Name,
+	}).Info("added author alias")
+	app.runRelinkAuthors()
+	c.Redirect(302, "/admin/authors")
+}
+
+func (app *booksingApp) deleteAuthorAlias(c *gin.Context) {
+	err := app.db.DeleteAuthorAlias(c.PostForm("id"))
+	if err != nil {
+		c.HTML(500, "error.html", V{
+			Error: err,
+		})
+		return
+	}
+	c.Redirect(302, "/admin/authors")
+}
+
+func (app *booksingApp) relinkAuthorsHandler(c *gin.Context) {
+	app.runRelinkAuthors()
+	c.Redirect(302, "/admin/authors")
+}
+
+func (app *booksingApp) runRelinkAuthors() {
+	go func() {
+		err := app.relinkAuthors()
+		if err != nil {
+			app.logger.WithError(err).Error("linking books to authors failed")
+		}
+	}()
+}
+
+func (app *booksingApp) showAuthors(c *gin.Context) {
+	authors, err := app.db.GetAuthors()
+	if err != nil {
+		c.HTML(500, "error.html", V{
+			Error: err,
+		})
+		return
+	}
+	counts, err := app.db.GetAuthorBookCounts()
+	if err != nil {
+		c.HTML(500, "error.html", V{
+			Error: err,
+		})
+		return
+	}
+
+	perLetter := make(map[string]int)
+	for _, a := range authors {
+		if counts[a.ID] > 0 {
+			perLetter[a.Letter()]++
+		}
+	}
+
+	letter := strings.ToUpper(c.Query("letter"))
+	if len(letter) != 1 || !strings.Contains(indexLetters, letter) {
+		letter = "A"
+		for _, l := range indexLetters {
+			if perLetter[string(l)] > 0 {
+				letter = string(l)
+				break
+			}
+		}
+	}
+
+	var letters []letterView
+	for _, l := range indexLetters {
+		letters = append(letters, letterView{
+			Letter:  string(l),
+			Authors: perLetter[string(l)],
+		})
+	}
+
+	var views []authorView
+	for _, a := range authors {
+		if a.Letter() != letter || counts[a.ID] == 0 {
+			continue
+		}
+		views = append(views, authorView{
+			Author: a,
+			Books:  counts[a.ID],
+		})
+	}
+
+	c.HTML(200, "authors.html", V{
+		Q:          "",
+		IsAdmin:    c.GetBool("isAdmin"),
+		TotalBooks: app.db.GetBookCount(),
+		Letter:     letter,
+		Letters:    letters,
+		Authors:    views,
+		Indexing:   app.state == "indexing",
+	})
+}
+
+func (app *booksingApp) showAuthor(c *gin.Context) {
+	author, err := app.db.GetAuthor(c.Param("id"))
+	if err == booksing.ErrNotFound {
+		c.HTML(404, "error.html", V{
+			Error: errors.New("Author not found"),
+		})
+		return
+	} else if err != nil {
+		c.HTML(500, "error.html", V{
+			Error: err,
+		})
+		return
+	}
+
+	books, err := app.db.GetAuthorBooks(author.ID)
+	if err != nil {
+		c.HTML(500, "error.html", V{
+			Error: err,
+		})
+		return
+	}
+
+	user := c.MustGet("id").(*booksing.User)
+	user.SetIcons(books)
+
+	c.HTML(200, "author.html", V{
+		Q:          "",
+		IsAdmin:    c.GetBool("isAdmin"),
+		TotalBooks: app.db.GetBookCount(),
+		Author:     author,
+		Results:    int64(len(books)),
+		Series:     booksing.GroupBySeries(books),
+		Indexing:   app.state == "indexing",
+	})
+}
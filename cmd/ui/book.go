@@ -0,0 +1,269 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gnur/booksing"
+	"github.com/gnur/booksing/epub"
+	"github.com/sirupsen/logrus"
+)
+
+// bookView holds everything shown on the page of a single book
+type bookView struct {
+	Book        *booksing.Book
+	Authors     []booksing.Author
+	Downloads   int
+	Fields      []fieldView `json:"-"`
+	SeriesBooks []booksing.Book
+	AuthorBooks []booksing.Book
+	Related     []booksing.Book
+}
+
+// fieldView is a metadata field of a book and where its value came from
+type fieldView struct {
+	Name   string
+	Value  string
+	Source booksing.MetadataSource
+}
+
+func (app *booksingApp) showBook(c *gin.Context) {
+	user := c.MustGet("id").(*booksing.User)
+
+	book, err := app.db.GetBook(c.Param("hash"))
+	if err == booksing.ErrNotFound {
+		c.HTML(404, "error.html", V{
+			Error: errors.New("Book not found"),
+		})
+		return
+	} else if err != nil {
+		c.HTML(500, "error.html", V{
+			Error: err,
+		})
+		return
+	}
+
+	c.HTML(200, "book.html", V{
+		Q:          "",
+		IsAdmin:    c.GetBool("isAdmin"),
+		TotalBooks: app.db.GetBookCount(),
+		Indexing:   app.state == "indexing",
+		Book:       book,
+		Detail:     app.newBookView(book, user),
+	})
+}
+
+// newBookView collects the books in the same series, by the same authors and
+// like a book, the icons are set to the shelves of user
+func (app *booksingApp) newBookView(book *booksing.Book, user *booksing.User) *bookView {
+	book.Icon = user.Icon(book.Hash)
+
+	view := bookView{
+		Book:      book,
+		Downloads: app.db.GetDownloadCount(book.Hash),
+		Fields: []fieldView{
+			{"title", book.Title, book.Sources["title"]},
+			{"author", book.Author, book.Sources["author"]},
+			{"series", book.Series, book.Sources["series"]},
+			{"language", book.Language, book.Sources["language"]},
+			{"description", book.Description, book.Sources["description"]},
+		},
+	}
+
+	inSeries := make(map[string]bool)
+	if book.Series != "" {
+		q, err := booksing.ParseQuery(booksing.QueryFilter("series", book.Series))
+		if err == nil {
+			err = q.SetSort("series")
+		}
+		if err == nil {
+			res, err := app.db.GetBooks(q, 100, 0)
+			if err != nil {
+				app.logger.WithError(err).Warning("could not get books in series")
+			} else {
+				view.SeriesBooks = res.Items
+			}
+		}
+		for _, b := range view.SeriesBooks {
+			inSeries[b.Hash] = true
+		}
+	}
+
+	seen := map[string]bool{book.Hash: true}
+	for _, id := range book.AuthorIDs {
+		author, err := app.db.GetAuthor(id)
+		if err == nil {
+			view.Authors = append(view.Authors, *author)
+		}
+		books, err := app.db.GetAuthorBooks(id)
+		if err != nil {
+			app.logger.WithError(err).Warning("could not get books of author")
+			continue
+		}
+		for _, b := range books {
+			if seen[b.Hash] || inSeries[b.Hash] {
+				continue
+			}
+			seen[b.Hash] = true
+			view.AuthorBooks = append(view.AuthorBooks, b)
+		}
+	}
+
+	read, _ := booksing.ParseShelf("read")
+	related, err := app.db.GetRelatedBooks(book.Hash, user.Shelf(read), maxRelated)
+	if err != nil {
+		app.logger.WithError(err).Warning("could not get related books")
+	}
+	view.Related = related
+
+	user.SetIcons(view.SeriesBooks)
+	user.SetIcons(view.AuthorBooks)
+	user.SetIcons(view.Related)
+	return &view
+}
+
+// coverTypes are the types of images that are served as a cover
+var coverTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// serveCover returns the cover image from the epub of a book
+func (app *booksingApp) serveCover(c *gin.Context) {
+	book, err := app.db.GetBook(c.Param("hash"))
+	if err != nil {
+		c.Status(404)
+		return
+	}
+	for _, f := range book.AllFiles() {
+		if f.Format != "epub" {
+			continue
+		}
+		data, err := epub.Cover(f.Path)
+		if err != nil {
+			continue
+		}
+		mediaType := http.DetectContentType(data)
+		if !coverTypes[mediaType] {
+			app.logger.WithFields(logrus.Fields{
+				"hash": book.Hash,
+				"type": mediaType,
+			}).Warning("cover is not an image")
+			continue
+		}
+		c.Header("Cache-Control", "max-age=86400")
+		c.Header("X-Content-Type-Options", "nosniff")
+		c.Data(200, mediaType, data)
+		return
+	}
+	c.Status(404)
+}
+
+// editBook stores metadata the admin changed, the fields that changed are
+// marked as set by hand
+func (app *booksingApp) editBook(c *gin.Context) {
+	defer app.bookLocks.Lock(c.Param("hash"))()
+
+	book, err := app.db.GetBook(c.Param("hash"))
+	if err != nil {
+		c.HTML(404, "error.html", V{
+			Error: err,
+		})
+		return
+	}
+	if book.Sources == nil {
+		book.Sources = make(map[string]booksing.MetadataSource)
+	}
+
+	set := func(field string, current *string, value string) {
+		//browsers send the lines of a textarea with CRLF line endings
+		value = strings.TrimSpace(strings.Replace(value, "\r\n", "\n", -1))
+		if value == strings.TrimSpace(*current) {
+			return
+		}
+		*current = value
+		book.Sources[field] = booksing.SourceManual
+	}
+	set("title", &book.Title, c.PostForm("title"))
+	set("series", &book.Series, c.PostForm("series"))
+	set("description", &book.Description, c.PostForm("description"))
+	set("language", &book.Language, booksing.FixLang(c.PostForm("language")))
+	if author := strings.TrimSpace(c.PostForm("author")); author != book.Author {
+		book.SetAuthor(author)
+		book.Sources["author"] = booksing.SourceManual
+	}
+	if index := c.PostForm("seriesindex"); index != "" {
+		book.SeriesIndex, err = strconv.ParseFloat(index, 64)
+		if err != nil {
+			c.HTML(400, "error.html", V{
+				Error: errors.New("The series index must be a number"),
+			})
+			return
+		}
+	}
+
+	err = app.saveBook(book)
+	if err != nil {
+		c.HTML(500, "error.html", V{
+			Error: err,
+		})
+		return
+	}
+	c.Redirect(302, "/book/"+book.Hash)
+}
+
+// reparseBook reads the metadata of a book from its file again, fields that
+// were set by hand are kept
+func (app *booksingApp) reparseBook(c *gin.Context) {
+	defer app.bookLocks.Lock(c.Param("hash"))()
+
+	book, err := app.db.GetBook(c.Param("hash"))
+	if err != nil {
+		c.HTML(404, "error.html", V{
+			Error: err,
+		})
+		return
+	}
+
+	parsed, err := booksing.NewBookFromFile(book.Path, app.importOptions)
+	if err != nil {
+		c.HTML(500, "error.html", V{
+			Error: fmt.Errorf("Unable to parse %s: %w", book.Path, err),
+		})
+		return
+	}
+	book.Reparse(parsed)
+
+	err = app.saveBook(book)
+	if err != nil {
+		c.HTML(500, "error.html", V{
+			Error: err,
+		})
+		return
+	}
+	app.logger.WithFields(logrus.Fields{
+		"hash": book.Hash,
+		"path": book.Path,
+	}).Info("book was parsed again")
+	c.Redirect(302, "/book/"+book.Hash)
+}
+
+// saveBook links the authors of a changed book and stores it
+func (app *booksingApp) saveBook(book *booksing.Book) error {
+	err := app.linkAuthors(book)
+	if err != nil {
+		return fmt.Errorf("Unable to link authors: %w", err)
+	}
+	err = app.db.AddBooks([]booksing.Book{*book}, true)
+	if err != nil {
+		return fmt.Errorf("Unable to store book: %w", err)
+	}
+	app.suggest.Add(*book)
+	return nil
+}
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
+	"unicode"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gnur/booksing"
@@ -43,6 +47,19 @@ func (app *booksingApp) downloadBook(c *gin.Context) {
 		}).Error("could not find book")
 		return
 	}
+	app.sendBookFile(c, book)
+}
+
+// sendBookFile records the download of a file of a book and sends it, the
+// file query parameter picks the file
+func (app *booksingApp) sendBookFile(c *gin.Context, book *booksing.Book) {
+	files := book.AllFiles()
+	index, err := strconv.Atoi(c.DefaultQuery("file", "0"))
+	if err != nil || index < 0 || index >= len(files) {
+		abortWithError(c, 404, errors.New("File not found"))
+		return
+	}
+	file := files[index]
 	user := c.MustGet("id")
 	username := user.(*booksing.User).Name
 
@@ -63,6 +80,7 @@ func (app *booksingApp) downloadBook(c *gin.Context) {
 			"user": username,
 			"ip":   ip,
 			"book": book.Hash,
+			"file": file.Path,
 		})
 		if err != nil {
 			app.logger.WithField("err", err).Error("could not create dl event")
@@ -73,10 +91,21 @@ func (app *booksingApp) downloadBook(c *gin.Context) {
 		}
 	}
 
-	fName := path.Base(book.Path)
+	fName := path.Base(file.Path)
 	c.Header("Content-Disposition",
-		fmt.Sprintf("attachment; filename=\"%s\"", fName))
-	c.File(book.Path)
+		fmt.Sprintf("attachment; filename=\"%s\"; filename*=UTF-8''%s", asciiFilename(fName), url.PathEscape(fName)))
+	c.File(file.Path)
+}
+
+// asciiFilename returns name in a form that is safe to use in a header for
+// clients that don't support utf-8 filenames
+func asciiFilename(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r > unicode.MaxASCII || r < ' ' || r == '"' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, booksing.Transliterate(name))
 }
 
 func (app *booksingApp) updateUser(c *gin.Context) {
@@ -259,7 +288,7 @@ func (app *booksingApp) bookParser() {
 	for filename := range app.bookQ {
 		app.logger.WithField("f", filename).Debug("parsing book")
 		start := time.Now()
-		book, err := booksing.NewBookFromFile(filename, app.bookDir)
+		book, err := booksing.NewBookFromFile(filename, app.importOptions)
 		duration := time.Since(start).Microseconds()
 		epubParseProccessed.Inc()
 		epubParseTime.Add(float64(duration) / 1000000)
@@ -272,6 +301,16 @@ func (app *booksingApp) bookParser() {
 			app.moveBookToFailed(filename)
 			continue
 		}
+		err = app.linkAuthors(book)
+		if err != nil {
+			app.logger.WithFields(logrus.Fields{
+				"file": filename,
+				"err":  err,
+			}).Warning("Unable to link book to its authors")
+		}
+		book.Hash = app.hasher.Hash(book)
+		book.Imported = time.Now().In(app.timezone)
+
 		exists, err := app.db.HasHash(book.Hash)
 		if err != nil {
 			app.logger.WithFields(logrus.Fields{
@@ -284,14 +323,46 @@ func (app *booksingApp) bookParser() {
 			continue
 		}
 
-		//all books get added to search, even duplicates
-		app.searchQ <- *book
+		owner, claimed, err := app.db.ClaimChecksum(book.Checksum, book.Hash)
+		if err != nil {
+			app.logger.WithFields(logrus.Fields{
+				"hash": book.Hash,
+				"err":  err,
+			}).Warning("Unable to store checksum in db")
+			app.resultQ <- DBErrorBook
+			app.moveBookToFailed(filename)
+			dbErrors.WithLabelValues("write").Inc()
+			continue
+		}
+		if !claimed {
+			app.logger.WithFields(logrus.Fields{
+				"file":     filename,
+				"existing": owner,
+			}).Info("Deleting exact copy of existing book")
+			err = os.Remove(filename)
+			if err != nil {
+				app.logger.WithError(err).Error("unable to delete exact copy")
+			}
+			app.resultQ <- DuplicateBook
+			continue
+		}
 
 		if exists {
+			app.handleDuplicate(book)
 			app.resultQ <- DuplicateBook
 			continue
 		}
 
+		err = book.Move(app.library)
+		if err != nil {
+			app.logger.WithFields(logrus.Fields{
+				"file": filename,
+				"err":  err,
+			}).Warning("Unable to move book to library")
+		}
+
+		app.searchQ <- *book
+
 		start = time.Now()
 		err = app.db.AddHash(book.Hash)
 		if err != nil {
@@ -378,11 +449,13 @@ func (app *booksingApp) searchUpdater() {
 				duration := time.Since(start).Microseconds()
 				searchProcessed.Add(float64(len(books)))
 				searchTime.Add(float64(duration) / 1000000)
+				app.notifySavedSearches(books)
 			}
 
 			books = []booksing.Book{}
 			lastSave = time.Now()
 		case b := <-app.searchQ:
+			app.suggest.Add(b)
 			books = append(books, b)
 
 			if len(books) >= app.cfg.BatchSize {
@@ -398,6 +471,7 @@ func (app *booksingApp) searchUpdater() {
 					duration := time.Since(start).Microseconds()
 					searchProcessed.Add(float64(len(books)))
 					searchTime.Add(float64(duration) / 1000000)
+					app.notifySavedSearches(books)
 				}
 				books = []booksing.Book{}
 				lastSave = time.Now()
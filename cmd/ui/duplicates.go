@@ -0,0 +1,424 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gnur/booksing"
+	"github.com/sirupsen/logrus"
+)
+
+// duplicatePair holds a queued duplicate and the book it collided with
+type duplicatePair struct {
+	Duplicate booksing.Duplicate
+	Existing  *booksing.Book
+}
+
+// hashLocks serializes changes to the same book, a lock is removed again
+// when nobody holds or waits for it
+type hashLocks struct {
+	mu    sync.Mutex
+	locks map[string]*hashLock
+}
+
+type hashLock struct {
+	sync.Mutex
+	users int
+}
+
+// Lock locks the book with hash and returns the function that unlocks it
+func (l *hashLocks) Lock(hash string) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*hashLock)
+	}
+	lock, ok := l.locks[hash]
+	if !ok {
+		lock = &hashLock{}
+		l.locks[hash] = lock
+	}
+	lock.users++
+	l.mu.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		l.mu.Lock()
+		lock.users--
+		if lock.users == 0 {
+			delete(l.locks, hash)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// LockAll locks the books with the given hashes, always in the same order so
+// two callers can't wait on each other
+func (l *hashLocks) LockAll(hashes ...string) func() {
+	sorted := append([]string{}, hashes...)
+	sort.Strings(sorted)
+	var unlocks []func()
+	for i, h := range sorted {
+		if i > 0 && h == sorted[i-1] {
+			continue
+		}
+		unlocks = append(unlocks, l.Lock(h))
+	}
+	return func() {
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			unlocks[i]()
+		}
+	}
+}
+
+// handleDuplicate decides, based on the duplicate policy, which of the two
+// files is kept in the library, the other one goes to the duplicates queue.
+// Duplicates of the same book are handled one at a time and the changed book
+// is stored right away, so the next one starts from the stored files.
+func (app *booksingApp) handleDuplicate(candidate *booksing.Book) {
+	if into, err := app.mergedInto(candidate.Hash); err == nil {
+		candidate.Hash = into
+	}
+	logger := app.logger.WithFields(logrus.Fields{
+		"hash": candidate.Hash,
+		"file": candidate.Path,
+	})
+
+	unlock := app.bookLocks.Lock(candidate.Hash)
+	defer unlock()
+
+	existing, err := app.db.GetBook(candidate.Hash)
+	if err == nil && sameChecksum(existing, candidate) {
+		//exact copy that was imported before the existing book was stored
+		logger.Info("Deleting exact copy of existing book")
+		err = os.Remove(candidate.Path)
+		if err != nil {
+			logger.WithError(err).Error("unable to delete exact copy")
+		}
+		return
+	}
+	if err == nil && app.cfg.AttachDuplicates && !existing.SameFile(candidate) {
+		if app.dupPolicy.PreferNew(existing, candidate) {
+			err = app.makePrimary(existing, candidate)
+			existing = candidate
+		} else {
+			err = existing.Attach(candidate, app.library)
+		}
+		if err == nil {
+			err = app.saveBook(existing)
+		}
+		if err == nil {
+			logger.Info("attached duplicate to existing book")
+			return
+		}
+		logger.WithError(err).Error("unable to attach duplicate to existing book")
+	} else if err == nil && app.dupPolicy.PreferNew(existing, candidate) {
+		loser := *existing
+		loser.Files = nil
+		err = app.moveToDuplicates(&loser)
+		if err == nil {
+			candidate.Files = existing.Files
+			err = candidate.Move(app.library)
+			if err != nil {
+				logger.WithError(err).Warning("Unable to move book to library")
+			}
+			err = app.saveBook(candidate)
+			if err != nil {
+				logger.WithError(err).Error("unable to store book")
+			}
+			app.queueDuplicate(&loser)
+			logger.Info("replaced existing book with duplicate")
+			return
+		}
+		logger.WithError(err).Error("unable to move existing book out of the library")
+	}
+
+	err = app.moveToDuplicates(candidate)
+	if err != nil {
+		logger.WithError(err).Error("unable to move duplicate book")
+		return
+	}
+	app.queueDuplicate(candidate)
+}
+
+// mergedInto returns the hash of the book that the book with hash was merged
+// into, following books that were merged again
+func (app *booksingApp) mergedInto(hash string) (string, error) {
+	into, err := app.db.GetMergedHash(hash)
+	for i := 0; err == nil && i < 10; i++ {
+		next, nextErr := app.db.GetMergedHash(into)
+		if nextErr != nil {
+			return into, nil
+		}
+		into = next
+	}
+	return into, err
+}
+
+func sameChecksum(existing, candidate *booksing.Book) bool {
+	for _, sum := range existing.Checksums() {
+		if sum == candidate.Checksum {
+			return true
+		}
+	}
+	return false
+}
+
+// makePrimary makes candidate the primary file of the book, the current
+// primary file is kept as an attached file
+func (app *booksingApp) makePrimary(existing, candidate *booksing.Book) error {
+	oldPrimary := *existing
+	oldPrimary.Files = nil
+	candidate.Files = existing.Files
+	candidate.Imported = existing.Imported
+
+	err := candidate.Attach(&oldPrimary, app.library)
+	if err != nil {
+		return err
+	}
+	err = candidate.Move(app.library)
+	if err != nil {
+		//put everything back where it was
+		last := candidate.Files[len(candidate.Files)-1]
+		_ = os.Rename(last.Path, existing.Path)
+		return err
+	}
+	return nil
+}
+
+// moveToDuplicates moves the file of a book to the duplicates dir, or removes
+// it when duplicates are not kept
+func (app *booksingApp) moveToDuplicates(b *booksing.Book) error {
+	if !app.cfg.KeepDuplicates {
+		return os.Remove(b.Path)
+	}
+
+	err := os.MkdirAll(app.cfg.DuplicateDir, 0755)
+	if err != nil {
+		return fmt.Errorf("Unable to create duplicate dir: %w", err)
+	}
+
+	name := fmt.Sprintf("%s-%d%s", b.Hash, time.Now().UnixNano(), filepath.Ext(b.Path))
+	newPath := filepath.Join(app.cfg.DuplicateDir, name)
+	err = os.Rename(b.Path, newPath)
+	if err != nil {
+		return err
+	}
+	b.Path = newPath
+	return nil
+}
+
+func (app *booksingApp) queueDuplicate(b *booksing.Book) {
+	if !app.cfg.KeepDuplicates {
+		return
+	}
+	err := app.db.AddDuplicate(&booksing.Duplicate{
+		Hash:      b.Hash,
+		Candidate: *b,
+		Found:     time.Now().In(app.timezone),
+	})
+	if err != nil {
+		dbErrors.WithLabelValues("write").Inc()
+		app.logger.WithError(err).WithField("hash", b.Hash).Error("could not store duplicate")
+	}
+}
+
+func (app *booksingApp) showDuplicates(c *gin.Context) {
+	dups, err := app.db.GetDuplicates()
+	if err != nil {
+		c.HTML(500, "error.html", V{
+			Error: err,
+		})
+		c.Abort()
+		return
+	}
+
+	var pairs []duplicatePair
+	for _, d := range dups {
+		pair := duplicatePair{
+			Duplicate: d,
+		}
+		existing, err := app.db.GetBook(d.Hash)
+		if err == nil {
+			pair.Existing = existing
+		}
+		pairs = append(pairs, pair)
+	}
+
+	c.HTML(200, "duplicates.html", V{
+		Q:          "",
+		IsAdmin:    c.GetBool("isAdmin"),
+		TotalBooks: app.db.GetBookCount(),
+		Duplicates: pairs,
+		Indexing:   app.state == "indexing",
+	})
+}
+
+// swapDuplicate puts the queued file in the library and queues the file it
+// replaced, so swapping again restores the original situation
+func (app *booksingApp) swapDuplicate(c *gin.Context) {
+	d, ok := app.getDuplicateParam(c)
+	if !ok {
+		return
+	}
+	candidate := d.Candidate
+
+	unlock := app.bookLocks.Lock(d.Hash)
+	defer unlock()
+
+	existing, err := app.db.GetBook(d.Hash)
+	if err != nil && err != booksing.ErrNotFound {
+		c.HTML(500, "error.html", V{
+			Error: err,
+		})
+		return
+	}
+
+	if err == booksing.ErrNotFound {
+		err = app.promoteDuplicate(d)
+	} else {
+		loser := *existing
+		loser.Files = nil
+		err = app.moveToDuplicates(&loser)
+		if err == nil {
+			candidate.Files = existing.Files
+			err = candidate.Move(app.library)
+			if err != nil {
+				_ = os.Rename(loser.Path, existing.Path)
+			} else {
+				err = app.saveBook(&candidate)
+			}
+			if err == nil {
+				d.Candidate = loser
+				d.Found = time.Now().In(app.timezone)
+				err = app.db.AddDuplicate(d)
+			}
+		}
+	}
+	if err != nil {
+		app.logger.WithFields(logrus.Fields{
+			"hash": d.Hash,
+			"err":  err,
+		}).Error("Could not swap duplicate")
+		c.HTML(500, "error.html", V{
+			Error: fmt.Errorf("Unable to swap duplicate: %w", err),
+		})
+		return
+	}
+
+	app.logger.WithField("hash", d.Hash).Info("swapped duplicate")
+	c.Redirect(302, c.Request.Referer())
+}
+
+// promoteDuplicate adds a queued file as a new book, used when the book it
+// collided with no longer exists
+func (app *booksingApp) promoteDuplicate(d *booksing.Duplicate) error {
+	candidate := d.Candidate
+	err := candidate.Move(app.library)
+	if err != nil {
+		return err
+	}
+	err = app.db.AddBooks([]booksing.Book{candidate}, true)
+	if err != nil {
+		return err
+	}
+	app.suggest.Add(candidate)
+	err = app.db.AddHash(candidate.Hash)
+	if err != nil {
+		return err
+	}
+	err = app.db.UpdateBookCount(1)
+	if err != nil {
+		app.logger.WithError(err).Error("could not update book count")
+	}
+	return app.db.DeleteDuplicate(d.ID)
+}
+
+// attachDuplicate adds the queued file to the files of the book it collided with
+func (app *booksingApp) attachDuplicate(c *gin.Context) {
+	d, ok := app.getDuplicateParam(c)
+	if !ok {
+		return
+	}
+
+	existing, err := app.db.GetBook(d.Hash)
+	if err == booksing.ErrNotFound {
+		err = app.promoteDuplicate(d)
+	} else if err == nil {
+		err = existing.Attach(&d.Candidate, app.library)
+		if err == nil {
+			err = app.db.AddBooks([]booksing.Book{*existing}, true)
+		}
+		if err == nil {
+			err = app.db.DeleteDuplicate(d.ID)
+		}
+	}
+	if err != nil {
+		app.logger.WithFields(logrus.Fields{
+			"hash": d.Hash,
+			"err":  err,
+		}).Error("Could not attach duplicate")
+		c.HTML(500, "error.html", V{
+			Error: fmt.Errorf("Unable to attach duplicate: %w", err),
+		})
+		return
+	}
+
+	app.logger.WithField("hash", d.Hash).Info("attached duplicate")
+	c.Redirect(302, c.Request.Referer())
+}
+
+func (app *booksingApp) discardDuplicate(c *gin.Context) {
+	d, ok := app.getDuplicateParam(c)
+	if !ok {
+		return
+	}
+
+	err := os.Remove(d.Candidate.Path)
+	if err != nil && !os.IsNotExist(err) {
+		c.HTML(500, "error.html", V{
+			Error: fmt.Errorf("Unable to delete duplicate from filesystem: %w", err),
+		})
+		return
+	}
+	err = app.db.DeleteDuplicate(d.ID)
+	if err != nil {
+		c.HTML(500, "error.html", V{
+			Error: fmt.Errorf("Unable to delete duplicate from database: %w", err),
+		})
+		return
+	}
+
+	app.logger.WithField("hash", d.Hash).Info("discarded duplicate")
+	c.Redirect(302, c.Request.Referer())
+}
+
+func (app *booksingApp) getDuplicateParam(c *gin.Context) (*booksing.Duplicate, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.HTML(400, "error.html", V{
+			Error: errors.New("Invalid duplicate id"),
+		})
+		return nil, false
+	}
+	d, err := app.db.GetDuplicate(id)
+	if err == booksing.ErrNotFound {
+		c.HTML(404, "error.html", V{
+			Error: errors.New("Duplicate not found"),
+		})
+		return nil, false
+	} else if err != nil {
+		c.HTML(500, "error.html", V{
+			Error: err,
+		})
+		return nil, false
+	}
+	return d, true
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gnur/booksing"
+	zglob "github.com/mattn/go-zglob"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	fsckLocker = stateUnlocked
+)
+
+func (app *booksingApp) showFsck(c *gin.Context) {
+	report, err := app.db.GetLastFsckReport(false)
+	if err == booksing.ErrNotFound {
+		report = nil
+	} else if err != nil {
+		c.HTML(500, "error.html", V{
+			Error: err,
+		})
+		c.Abort()
+		return
+	}
+	integrity, err := app.db.GetLastFsckReport(true)
+	if err == booksing.ErrNotFound {
+		integrity = nil
+	} else if err != nil {
+		c.HTML(500, "error.html", V{
+			Error: err,
+		})
+		c.Abort()
+		return
+	}
+
+	c.HTML(200, "fsck.html", V{
+		Q:          "",
+		IsAdmin:    c.GetBool("isAdmin"),
+		TotalBooks: app.db.GetBookCount(),
+		Fsck:       report,
+		Integrity:  integrity,
+		Reindex:    app.db.GetIndexProgress(),
+		Checking:   atomic.LoadUint32(&fsckLocker) == stateLocked,
+		Indexing:   app.state == "indexing",
+	})
+}
+
+func (app *booksingApp) runFsck(c *gin.Context) {
+	fix := c.PostForm("fix") == "true"
+
+	if atomic.LoadUint32(&fsckLocker) == stateLocked {
+		c.HTML(409, "error.html", V{
+			Error: errors.New("A library check is already running"),
+		})
+		return
+	}
+	if atomic.LoadUint32(&locker) == stateLocked {
+		c.HTML(409, "error.html", V{
+			Error: errors.New("A refresh is in progress, check the library when it is done"),
+		})
+		return
+	}
+
+	go func() {
+		_, err := app.fsck(fix)
+		if err != nil {
+			app.logger.WithError(err).Error("library check failed")
+		}
+	}()
+
+	c.Redirect(302, "/admin/fsck")
+}
+
+// fsck compares the database, the search index, the stored hashes and the
+// files in the book dir and optionally repairs whatever doesn't line up.
+func (app *booksingApp) fsck(fix bool) (*booksing.FsckReport, error) {
+	if !atomic.CompareAndSwapUint32(&fsckLocker, stateUnlocked, stateLocked) {
+		return nil, errors.New("library check is already running")
+	}
+	defer atomic.StoreUint32(&fsckLocker, stateUnlocked)
+
+	//make sure no new books are imported while checking
+	if !atomic.CompareAndSwapUint32(&locker, stateUnlocked, stateLocked) {
+		return nil, errors.New("not checking library because a refresh is running")
+	}
+	defer atomic.StoreUint32(&locker, stateUnlocked)
+
+	report := booksing.FsckReport{
+		StartTime: time.Now().In(app.timezone),
+		Fix:       fix,
+	}
+	logger := app.logger.WithField("fix", fix)
+	logger.Info("starting library check")
+
+	books, err := app.db.GetAllBooks()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get books from db: %w", err)
+	}
+	report.Books = len(books)
+
+	known := make(map[string]bool)
+	hashes := make(map[string]bool)
+	var remaining []booksing.Book
+
+	for i := range books {
+		b := &books[i]
+
+		issues, changed, removed := app.checkBookFiles(b, fix)
+		if changed {
+			err = app.db.AddBooks([]booksing.Book{*b}, true)
+			if err != nil {
+				logger.WithError(err).WithField("hash", b.Hash).Error("could not update book")
+				for j := range issues {
+					issues[j].Fixed = false
+				}
+			}
+		}
+		report.Issues = append(report.Issues, issues...)
+		if removed {
+			continue
+		}
+
+		hashes[b.Hash] = true
+		remaining = append(remaining, *b)
+		for _, f := range b.AllFiles() {
+			if abs, err := filepath.Abs(f.Path); err == nil {
+				known[abs] = true
+			}
+		}
+	}
+	books = remaining
+
+	indexed, err := app.db.GetIndexedHashes()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get hashes from search index: %w", err)
+	}
+	inIndex := make(map[string]bool)
+	for _, h := range indexed {
+		inIndex[h] = true
+		if hashes[h] {
+			continue
+		}
+		issue := booksing.FsckIssue{
+			Type:   booksing.IndexOrphan,
+			Hash:   h,
+			Detail: "search index contains a book that is not in the database",
+		}
+		if fix {
+			issue.Fixed = app.db.DeleteBook(h) == nil
+			app.suggest.Remove(h)
+		}
+		report.Issues = append(report.Issues, issue)
+	}
+	for _, b := range books {
+		if inIndex[b.Hash] {
+			continue
+		}
+		issue := booksing.FsckIssue{
+			Type:   booksing.NotIndexed,
+			Hash:   b.Hash,
+			Path:   b.Path,
+			Detail: "book is missing from the search index",
+		}
+		if fix {
+			issue.Fixed = app.db.AddBooks([]booksing.Book{b}, true) == nil
+			if issue.Fixed {
+				app.suggest.Add(b)
+			}
+		}
+		report.Issues = append(report.Issues, issue)
+	}
+
+	stored, err := app.db.GetHashes()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get stored hashes: %w", err)
+	}
+	hasHash := make(map[string]bool)
+	for _, h := range stored {
+		hasHash[h] = true
+		if hashes[h] {
+			continue
+		}
+		if into, err := app.mergedInto(h); err == nil && hashes[into] {
+			continue
+		}
+		issue := booksing.FsckIssue{
+			Type:   booksing.StaleHash,
+			Hash:   h,
+			Detail: "hash is stored but no book uses it, a new import with this hash would be rejected",
+		}
+		if fix {
+			issue.Fixed = app.db.DeleteHash(h) == nil
+		}
+		report.Issues = append(report.Issues, issue)
+	}
+	for _, b := range books {
+		if hasHash[b.Hash] {
+			continue
+		}
+		issue := booksing.FsckIssue{
+			Type:   booksing.MissingHash,
+			Hash:   b.Hash,
+			Path:   b.Path,
+			Detail: "hash of book is not stored, duplicates of this book will be imported",
+		}
+		if fix {
+			issue.Fixed = app.db.AddHash(b.Hash) == nil
+		}
+		report.Issues = append(report.Issues, issue)
+	}
+
+	orphans, files, err := app.findOrphans(known)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to scan book dir: %w", err)
+	}
+	report.Files = files
+	for _, o := range orphans {
+		issue := booksing.FsckIssue{
+			Type:   booksing.OrphanFile,
+			Path:   o,
+			Detail: "file is not part of the library",
+		}
+		if fix {
+			issue.Fixed = app.moveToImport(o) == nil
+			if issue.Fixed {
+				issue.Detail = "file moved to import dir"
+			}
+		}
+		report.Issues = append(report.Issues, issue)
+	}
+
+	count := app.db.GetBookCount()
+	if count != len(books) {
+		issue := booksing.FsckIssue{
+			Type:   booksing.WrongCount,
+			Detail: fmt.Sprintf("total is %d but database contains %d books", count, len(books)),
+		}
+		if fix {
+			issue.Fixed = app.db.SetBookCount(len(books)) == nil
+		}
+		report.Issues = append(report.Issues, issue)
+	}
+
+	report.StopTime = time.Now().In(app.timezone)
+	err = app.db.SaveFsckReport(&report)
+	if err != nil {
+		logger.WithError(err).Error("could not store library check report")
+	}
+
+	logger.WithFields(logrus.Fields{
+		"books":     report.Books,
+		"files":     report.Files,
+		"issues":    len(report.Issues),
+		"fixed":     report.Fixed(),
+		"timetaken": report.StopTime.Sub(report.StartTime).String(),
+	}).Info("finished library check")
+
+	return &report, nil
+}
+
+// checkBookFiles verifies the primary and attached files of a book, removed
+// is true when the book has been removed from the library.
+func (app *booksingApp) checkBookFiles(b *booksing.Book, fix bool) (issues []booksing.FsckIssue, changed, removed bool) {
+	issue, changed := app.checkBookFile(b, fix)
+	if issue != nil {
+		issues = append(issues, *issue)
+		if issue.Type == booksing.MissingFile && issue.Fixed && !changed {
+			return issues, false, true
+		}
+	}
+
+	var files []booksing.BookFile
+	for _, f := range b.Files {
+		if _, err := os.Stat(f.Path); !os.IsNotExist(err) {
+			files = append(files, f)
+			continue
+		}
+		issue := booksing.FsckIssue{
+			Type:   booksing.MissingFile,
+			Hash:   b.Hash,
+			Path:   f.Path,
+			Detail: "attached file does not exist",
+		}
+		if fix {
+			issue.Detail = "attached file does not exist, it was detached from the book"
+			issue.Fixed = true
+			changed = true
+		}
+		issues = append(issues, issue)
+	}
+	if fix {
+		b.Files = files
+	}
+
+	return issues, changed, false
+}
+
+// checkBookFile verifies the primary file of a single book, changed is true when the
+// book has been modified and needs to be stored again.
+func (app *booksingApp) checkBookFile(b *booksing.Book, fix bool) (issue *booksing.FsckIssue, changed bool) {
+	expected := app.library.Path(b, 0, b.PrimaryFile())
+
+	_, err := os.Stat(b.Path)
+	if os.IsNotExist(err) {
+		issue = &booksing.FsckIssue{
+			Type:   booksing.MissingFile,
+			Hash:   b.Hash,
+			Path:   b.Path,
+			Detail: "file does not exist",
+		}
+		if _, err := os.Stat(expected); err == nil && expected != b.Path {
+			issue.Detail = fmt.Sprintf("file does not exist, but was found at %s", expected)
+			if fix {
+				b.Path = expected
+				issue.Fixed = true
+				changed = true
+			}
+		} else if fix {
+			err = app.db.DeleteBook(b.Hash)
+			if err == nil {
+				app.suggest.Remove(b.Hash)
+				issue.Detail = "file does not exist, book was removed from the library"
+				issue.Fixed = true
+			}
+		}
+		return issue, changed
+	} else if err != nil {
+		return &booksing.FsckIssue{
+			Type:   booksing.MissingFile,
+			Hash:   b.Hash,
+			Path:   b.Path,
+			Detail: fmt.Sprintf("unable to read file: %s", err),
+		}, false
+	}
+
+	if !isWithin(app.importDir, b.Path) {
+		return nil, false
+	}
+
+	issue = &booksing.FsckIssue{
+		Type:   booksing.MisplacedFile,
+		Hash:   b.Hash,
+		Path:   b.Path,
+		Detail: fmt.Sprintf("file should be at %s", expected),
+	}
+	if fix {
+		moved, err := booksing.MoveFile(b.Path, expected)
+		if err != nil {
+			issue.Detail = fmt.Sprintf("unable to move file to %s: %s", expected, err)
+			return issue, false
+		}
+		b.Path = moved
+		issue.Fixed = true
+		changed = true
+	}
+	return issue, changed
+}
+
+// findOrphans returns all epubs in the book dir that are not known, files
+// in the import, fail, trash and duplicate dirs are ignored.
+func (app *booksingApp) findOrphans(known map[string]bool) ([]string, int, error) {
+	matches, err := zglob.Glob(filepath.Join(app.bookDir, "/**/*.epub"))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var orphans []string
+	files := 0
+	for _, m := range matches {
+		if isWithin(app.importDir, m) || isWithin(app.cfg.FailDir, m) || isWithin(app.cfg.TrashDir, m) || isWithin(app.cfg.DuplicateDir, m) {
+			continue
+		}
+		files++
+		abs, err := filepath.Abs(m)
+		if err != nil {
+			continue
+		}
+		if !known[abs] {
+			orphans = append(orphans, m)
+		}
+	}
+	return orphans, files, nil
+}
+
+// moveToImport moves a file to the import dir, a number is added to its name
+// when a file with the same name is waiting to be imported
+func (app *booksingApp) moveToImport(file string) error {
+	_, err := booksing.MoveFile(file, filepath.Join(app.importDir, filepath.Base(file)))
+	return err
+}
+
+// isWithin reports whether p is located somewhere below dir
+func isWithin(dir, p string) bool {
+	if dir == "" {
+		return false
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	absP, err := filepath.Abs(p)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(absDir, absP)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
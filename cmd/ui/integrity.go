@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gnur/booksing"
+	"github.com/sirupsen/logrus"
+)
+
+func (app *booksingApp) integrityLoop() {
+	interval, err := time.ParseDuration(app.cfg.IntegrityInterval)
+	if err != nil {
+		app.logger.WithError(err).Error("invalid integrity interval, not checking file integrity")
+		return
+	}
+	for {
+		time.Sleep(interval)
+		_, err := app.checkIntegrity()
+		if err != nil {
+			app.logger.WithError(err).Error("integrity check failed")
+		}
+	}
+}
+
+func (app *booksingApp) runIntegrity(c *gin.Context) {
+	if atomic.LoadUint32(&fsckLocker) == stateLocked {
+		c.HTML(409, "error.html", V{
+			Error: errors.New("A library check is already running"),
+		})
+		return
+	}
+
+	go func() {
+		_, err := app.checkIntegrity()
+		if err != nil {
+			app.logger.WithError(err).Error("integrity check failed")
+		}
+	}()
+
+	c.Redirect(302, "/admin/fsck")
+}
+
+// checkIntegrity recomputes the checksum of every file in the library and
+// flags files that changed on disk, files without a checksum get one
+func (app *booksingApp) checkIntegrity() (*booksing.FsckReport, error) {
+	if !atomic.CompareAndSwapUint32(&fsckLocker, stateUnlocked, stateLocked) {
+		return nil, errors.New("library check is already running")
+	}
+	defer atomic.StoreUint32(&fsckLocker, stateUnlocked)
+
+	report := booksing.FsckReport{
+		StartTime: time.Now().In(app.timezone),
+		Integrity: true,
+	}
+	app.logger.Info("starting integrity check")
+
+	books, err := app.db.GetAllBooks()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get books from db: %w", err)
+	}
+	report.Books = len(books)
+
+	for i := range books {
+		b := &books[i]
+		changed := false
+
+		for j, f := range b.AllFiles() {
+			sum, err := booksing.FileChecksum(f.Path)
+			if os.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				app.logger.WithFields(logrus.Fields{
+					"file": f.Path,
+					"err":  err,
+				}).Warning("unable to compute checksum")
+				continue
+			}
+			report.Files++
+
+			if f.Checksum == sum {
+				continue
+			}
+			issue := booksing.FsckIssue{
+				Type: booksing.ChecksumMismatch,
+				Hash: b.Hash,
+				Path: f.Path,
+			}
+			if f.Checksum == "" {
+				issue.Type = booksing.MissingChecksum
+				issue.Detail = "checksum was stored"
+				issue.Fixed = true
+				changed = true
+				if j == 0 {
+					b.Checksum = sum
+				} else {
+					b.Files[j-1].Checksum = sum
+				}
+			} else {
+				issue.Detail = fmt.Sprintf("checksum changed from %s to %s", f.Checksum, sum)
+			}
+			report.Issues = append(report.Issues, issue)
+		}
+
+		if changed {
+			err = app.db.AddBooks([]booksing.Book{*b}, true)
+			if err != nil {
+				app.logger.WithError(err).WithField("hash", b.Hash).Error("could not store checksums")
+			}
+		}
+	}
+
+	integrityGauge.Set(float64(report.Count(booksing.ChecksumMismatch)))
+
+	report.StopTime = time.Now().In(app.timezone)
+	err = app.db.SaveFsckReport(&report)
+	if err != nil {
+		app.logger.WithError(err).Error("could not store integrity report")
+	}
+
+	app.logger.WithFields(logrus.Fields{
+		"books":     report.Books,
+		"files":     report.Files,
+		"changed":   report.Count(booksing.ChecksumMismatch),
+		"timetaken": report.StopTime.Sub(report.StartTime).String(),
+	}).Info("finished integrity check")
+
+	return &report, nil
+}
+
+func (app *booksingApp) bookByChecksum(c *gin.Context) {
+	book, err := app.db.GetBookByChecksum(c.Param("sum"))
+	if err == booksing.ErrNotFound {
+		apiError(c, 404, errors.New("No book with this checksum"))
+		return
+	} else if err != nil {
+		app.logger.WithError(err).Error("could not get book by checksum")
+		apiError(c, 500, errors.New("Internal server error"))
+		return
+	}
+	hidePaths(c, book)
+	c.JSON(200, book)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"html/template"
 	"io/ioutil"
@@ -24,10 +25,16 @@ type V struct {
 	Results    int64
 	Error      error
 	Books      []booksing.Book
+	Facets     []booksing.Facet
+	Highlights map[string][]booksing.Highlight
+	Sort       string
+	SortOrders []string
 	Book       *booksing.Book
+	Detail     *bookView
 	Users      []booksing.User
 	Downloads  []booksing.Download
 	Q          string
+	Query      *booksing.Query
 	TimeTaken  int
 	Stats      []booksing.BookCount
 	IsAdmin    bool
@@ -36,26 +43,58 @@ type V struct {
 	Limit      int64
 	Offset     int64
 	Indexing   bool
+	Checking   bool
+	Fsck       *booksing.FsckReport
+	Integrity  *booksing.FsckReport
+	Reindex    booksing.IndexProgress
+	Trash      []booksing.TrashedBook
+	Duplicates []duplicatePair
+	Similar    []similarGroup
+	Library    *libraryView
+	Aliases    []aliasView
+	Authors    []authorView
+	Author     *booksing.Author
+	Letter     string
+	Letters    []letterView
+	Series     []booksing.SeriesGroup
+
+	SavedSearches []savedSearchView
+	Notifications []booksing.Notification
 }
 
 type configuration struct {
-	AdminUser     string `default:"unknown"`
-	UserHeader    string `default:""`
-	AllowAllusers bool   `default:"true"`
-	BookDir       string `default:"."`
-	ImportDir     string `default:"./import"`
-	FailDir       string `default:"./failed"`
-	DatabaseDir   string `default:"./db/"`
-	LogLevel      string `default:"info"`
-	BindAddress   string `default:":7132"`
-	Timezone      string `default:"Europe/Amsterdam"`
-	MQTTEnabled   bool   `default:"false"`
-	MQTTTopic     string `default:"events"`
-	MQTTHost      string `default:"tcp://localhost:1883"`
-	MQTTClientID  string `default:"booksing"`
-	BatchSize     int    `default:"50"`
-	Workers       int    `default:"5"`
-	SaveInterval  string `default:"10s"`
+	AdminUser           string  `default:"unknown"`
+	UserHeader          string  `default:""`
+	AllowAllusers       bool    `default:"true"`
+	BookDir             string  `default:"."`
+	ImportDir           string  `default:"./import"`
+	FailDir             string  `default:"./failed"`
+	FilenamePatterns    string  `default:""`
+	PreferFilename      bool    `default:"false"`
+	TrashDir            string  `default:"./trash"`
+	TrashRetention      string  `default:"720h"`
+	DuplicateDir        string  `default:"./duplicates"`
+	DuplicatePolicy     string  `default:"first"`
+	HashStrategy        string  `default:"v1"`
+	KeepCasing          bool    `default:"true"`
+	KeepDuplicates      bool    `default:"true"`
+	PathTemplate        string  `default:""`
+	AttachDuplicates    bool    `default:"true"`
+	SimilarityInterval  string  `default:"24h"`
+	SimilarityThreshold float64 `default:"0.85"`
+	IntegrityInterval   string  `default:"168h"`
+	DatabaseDir         string  `default:"./db/"`
+	LogLevel            string  `default:"info"`
+	BindAddress         string  `default:":7132"`
+	Timezone            string  `default:"Europe/Amsterdam"`
+	UnicodeFilenames    bool    `default:"false"`
+	MQTTEnabled         bool    `default:"false"`
+	MQTTTopic           string  `default:"events"`
+	MQTTHost            string  `default:"tcp://localhost:1883"`
+	MQTTClientID        string  `default:"booksing"`
+	BatchSize           int     `default:"50"`
+	Workers             int     `default:"5"`
+	SaveInterval        string  `default:"10s"`
 }
 
 func main() {
@@ -72,6 +111,12 @@ func main() {
 	if cfg.ImportDir == "" {
 		cfg.ImportDir = path.Join(cfg.BookDir, "import")
 	}
+	if cfg.FilenamePatterns == "" {
+		cfg.FilenamePatterns = booksing.DefaultFilenamePatterns
+	}
+	if cfg.PathTemplate == "" {
+		cfg.PathTemplate = booksing.DefaultPathTemplate
+	}
 
 	var db database
 	log.WithField("dbpath", cfg.DatabaseDir).Debug("using this file")
@@ -86,6 +131,26 @@ func main() {
 		interval = 10 * time.Second
 	}
 
+	dupPolicy, err := booksing.ParseDuplicatePolicy(cfg.DuplicatePolicy)
+	if err != nil {
+		log.WithField("err", err).Fatal("could not parse duplicate policy")
+	}
+
+	hasher, err := booksing.ParseHashStrategy(cfg.HashStrategy)
+	if err != nil {
+		log.WithField("err", err).Fatal("could not parse hash strategy")
+	}
+
+	filenamePatterns, err := booksing.ParseFilenamePatterns(cfg.FilenamePatterns)
+	if err != nil {
+		log.WithField("err", err).Fatal("could not parse filename patterns")
+	}
+
+	pathTemplate, err := booksing.ParsePathTemplate(cfg.PathTemplate)
+	if err != nil {
+		log.WithField("err", err).Fatal("could not parse path template")
+	}
+
 	tz, err := time.LoadLocation(cfg.Timezone)
 	if err != nil {
 		log.WithField("err", err).Fatal("could not load timezone")
@@ -129,6 +194,41 @@ func main() {
 		resultQ:      make(chan parseResult),
 		searchQ:      make(chan booksing.Book),
 		saveInterval: interval,
+		dupPolicy:    dupPolicy,
+		suggest:      booksing.NewSuggestIndex(),
+	}
+	app.importOptions = booksing.ImportOptions{
+		FilenamePatterns: filenamePatterns,
+		PreferFilename:   cfg.PreferFilename,
+		KeepCasing:       cfg.KeepCasing,
+	}
+	app.library = booksing.Library{
+		Dir:      cfg.BookDir,
+		Template: pathTemplate,
+		Unicode:  cfg.UnicodeFilenames,
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "rehash" {
+		app.hasher, err = libraryHashStrategy(db, booksing.DefaultHashStrategy)
+		if err != nil {
+			log.WithField("err", err).Fatal("could not get hash strategy of library")
+		}
+		err = app.rehash(os.Args[2:])
+		if err != nil {
+			log.WithField("err", err).Error("could not rehash library")
+		}
+		return
+	}
+
+	app.hasher, err = libraryHashStrategy(db, hasher)
+	if err != nil {
+		log.WithField("err", err).Fatal("could not get hash strategy of library")
+	}
+	if app.hasher != hasher {
+		log.WithFields(log.Fields{
+			"library":    app.hasher.String(),
+			"configured": hasher.String(),
+		}).Warning("library uses a different hash strategy, run `booksing rehash -apply` to migrate")
 	}
 
 	if app.cfg.MQTTEnabled {
@@ -140,6 +240,15 @@ func main() {
 		app.mqttClient = mqttClient
 	}
 
+	go app.loadSuggestions()
+	go app.trashLoop()
+	go app.similarityLoop()
+	go app.integrityLoop()
+	go func() {
+		app.relinkAuthorsIfNeeded()
+		app.reorganizeIfChanged()
+	}()
+
 	if cfg.ImportDir != "" {
 		go app.refreshLoop()
 		for w := 0; w < 5; w++ { //not sure yet how concurrent-proof my solution is
@@ -176,10 +285,21 @@ func main() {
 	{
 		auth.GET("/", app.search)
 		auth.GET("/bookmarks", app.bookmarks)
+		auth.GET("/suggest", app.suggestions)
+		auth.GET("/book/:hash", app.showBook)
+		auth.GET("/cover/:hash", app.serveCover)
+		auth.GET("/authors", app.showAuthors)
+		auth.GET("/authors/:id", app.showAuthor)
+		auth.GET("/searches", app.showSavedSearches)
+		auth.POST("/searches", app.saveSearch)
+		auth.GET("/searches/:id", app.openSavedSearch)
+		auth.POST("/searches/:id/delete", app.deleteSavedSearch)
+		auth.GET("/notifications", app.unreadNotifications)
 		auth.GET("/rotateShelve/:hash", app.rotateIcon)
 		auth.POST("/rotateShelve/:hash", app.rotateIcon)
 		auth.GET("/download", app.downloadBook)
 		auth.GET("/icons/:hash", app.serveIcon)
+		auth.GET("/checksum/:sum", app.bookByChecksum)
 
 	}
 
@@ -189,11 +309,64 @@ func main() {
 		admin.GET("/users", app.showUsers)
 		admin.GET("/stats", app.showStats)
 		admin.GET("/downloads", app.showDownloads)
+		admin.GET("/fsck", app.showFsck)
+		admin.POST("/fsck", app.runFsck)
+		admin.POST("/integrity", app.runIntegrity)
+		admin.GET("/reindex", app.showReindex)
+		admin.POST("/reindex", app.runReindex)
 		admin.POST("/delete/:hash", app.deleteBook)
+		admin.POST("/book/:hash/edit", app.editBook)
+		admin.POST("/book/:hash/reparse", app.reparseBook)
+		admin.GET("/trash", app.showTrash)
+		admin.POST("/trash/:id/restore", app.restoreTrashedBook)
+		admin.POST("/trash/:id/purge", app.purgeTrashedBook)
+		admin.GET("/duplicates", app.showDuplicates)
+		admin.POST("/duplicates/:id/swap", app.swapDuplicate)
+		admin.POST("/duplicates/:id/attach", app.attachDuplicate)
+		admin.POST("/duplicates/:id/discard", app.discardDuplicate)
+		admin.GET("/similar", app.showSimilar)
+		admin.POST("/similar", app.runSimilar)
+		admin.POST("/similar/merge", app.mergeSimilar)
+		admin.POST("/similar/dismiss", app.dismissSimilar)
+		admin.GET("/authors", app.showAuthorAliases)
+		admin.POST("/authors/alias", app.addAuthorAlias)
+		admin.POST("/authors/alias/delete", app.deleteAuthorAlias)
+		admin.POST("/authors/relink", app.relinkAuthorsHandler)
+		admin.GET("/library", app.showLibrary)
+		admin.POST("/library/reorganize", app.runReorganize)
 		admin.POST("user/:username", app.updateUser)
 		admin.POST("/adduser", app.addUser)
 	}
 
+	r.GET("/api/v1/openapi.json", serveOpenAPI)
+
+	api := r.Group("/api/v1")
+	api.Use(app.BearerTokenMiddleware())
+	{
+		api.GET("/books", app.apiSearch)
+		api.GET("/books/:hash", app.apiShowBook)
+		api.GET("/books/:hash/related", app.apiRelatedBooks)
+		api.GET("/books/:hash/download", app.apiDownload)
+		api.GET("/shelves", app.apiShelves)
+		api.GET("/bookmarks", app.apiBookmarks)
+		api.PUT("/bookmarks/:hash", app.apiSetBookmark)
+		api.DELETE("/bookmarks/:hash", app.apiDeleteBookmark)
+		api.GET("/user", app.apiCurrentUser)
+	}
+
+	apiAdmin := r.Group("/api/v1")
+	apiAdmin.Use(app.BearerTokenMiddleware(), app.mustBeAdmin())
+	{
+		apiAdmin.GET("/users", app.apiUsers)
+		apiAdmin.PATCH("/users/:username", app.apiUpdateUser)
+		apiAdmin.GET("/stats", app.apiStats)
+		apiAdmin.GET("/downloads", app.apiDownloads)
+	}
+
+	r.NoRoute(func(c *gin.Context) {
+		abortWithError(c, 404, errors.New("Page not found"))
+	})
+
 	log.Info("booksing is now running")
 	port := os.Getenv("PORT")
 
@@ -26,6 +26,10 @@ var (
 		Name: "booksing_indexing",
 		Help: "Wether booksing is indexing or not",
 	})
+	integrityGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "booksing_integrity_failures",
+		Help: "Number of files whose checksum changed since they were imported",
+	})
 	totalBooksGauge = promauto.NewGauge(prometheus.GaugeOpts{
 		Name: "booksing_books_in_index",
 		Help: "Total number of books available for searching",
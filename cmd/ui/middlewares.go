@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"math"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -10,6 +11,24 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+var (
+	errInternal   = errors.New("internal server error")
+	errNotAllowed = errors.New("User is not allowed to perform this action")
+)
+
+// abortWithError stops handling a request, requests to the API get an error
+// object and other requests the error page
+func abortWithError(c *gin.Context, code int, err error) {
+	if strings.HasPrefix(c.Request.URL.Path, apiPrefix) {
+		apiError(c, code, err)
+	} else {
+		c.HTML(code, "error.html", V{
+			Error: err,
+		})
+	}
+	c.Abort()
+}
+
 // Logger is the logrus logger handler
 func Logger(log *logrus.Entry) gin.HandlerFunc {
 
@@ -75,10 +94,7 @@ func (app *booksingApp) BearerTokenMiddleware() gin.HandlerFunc {
 			err = app.db.SaveUser(&user)
 			if err != nil {
 				app.logger.WithField("err", err).Error("could not save new user")
-				c.JSON(500, gin.H{
-					"msg": "internal server error",
-				})
-				c.Abort()
+				abortWithError(c, 500, errInternal)
 				return
 			}
 		} else if err == nil {
@@ -86,25 +102,16 @@ func (app *booksingApp) BearerTokenMiddleware() gin.HandlerFunc {
 			err = app.db.SaveUser(&user)
 			if err != nil {
 				app.logger.Error("could not update user")
-				c.JSON(500, gin.H{
-					"msg": "internal server error",
-				})
-				c.Abort()
+				abortWithError(c, 500, errInternal)
 				return
 			}
 		} else {
 			app.logger.WithField("err", err).Error("could not get user")
-			c.JSON(500, gin.H{
-				"msg": "internal server error",
-			})
-			c.Abort()
+			abortWithError(c, 500, errInternal)
 			return
 		}
 		if !user.IsAllowed {
-			c.HTML(403, "error.html", V{
-				Error: errors.New("User is not allowed to perform this action"),
-			})
-			c.Abort()
+			abortWithError(c, 403, errNotAllowed)
 			return
 		}
 		if user.Bookmarks == nil {
@@ -119,10 +126,7 @@ func (app *booksingApp) BearerTokenMiddleware() gin.HandlerFunc {
 func (app *booksingApp) mustBeAdmin() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		if !c.GetBool("isAdmin") {
-			c.HTML(403, "error.html", V{
-				Error: errors.New("User is not allowed to perform this action"),
-			})
-			c.Abort()
+			abortWithError(c, 403, errNotAllowed)
 		}
 	}
 }
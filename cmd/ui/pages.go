@@ -5,8 +5,8 @@ import (
 	"fmt"
 	"math"
 	"net/http"
-	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -37,7 +37,29 @@ func (app *booksingApp) search(c *gin.Context) {
 		}
 	}
 
-	books, err := app.db.GetBooks(q, limit, offset)
+	u := c.MustGet("id")
+	user := u.(*booksing.User)
+
+	query, err := userQuery(q, user)
+	if err == nil {
+		err = query.SetSort(c.Query("s"))
+	}
+	if err != nil && c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEJSON {
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	} else if err != nil {
+		c.HTML(400, "search.html", V{
+			Error:      err,
+			Q:          q,
+			IsAdmin:    c.GetBool("isAdmin"),
+			TotalBooks: app.db.GetBookCount(),
+			Indexing:   app.state == "indexing",
+		})
+		return
+	}
+	books, err := app.db.GetBooks(query, limit, offset)
 	if err != nil {
 		c.HTML(500, "error.html", V{
 			Error: err,
@@ -46,33 +68,28 @@ func (app *booksingApp) search(c *gin.Context) {
 		return
 	}
 
-	u := c.MustGet("id")
-	user := u.(*booksing.User)
-	username := user.Name
-
-	for i, b := range books.Items {
-		icon, err := app.getUserIcon(username, b.Hash)
-		app.logger.WithFields(logrus.Fields{
-			"icon": icon,
-			"err":  err,
-		}).Info("checking bm")
-		b := &books.Items[i]
-		b.Icon, _ = app.getUserIcon(username, b.Hash)
-		app.logger.WithFields(logrus.Fields{
-			"icon": books.Items[i].Icon,
-		}).Info("Result in slice")
-	}
+	user.SetIcons(books.Items)
 
 	stop := time.Since(start)
 	latency := int(math.Ceil(float64(stop.Nanoseconds()) / 1000000.0))
+	if c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEJSON {
+		hideListPaths(c, books.Items)
+		c.JSON(200, books)
+		return
+	}
 	c.HTML(200, "search.html", V{
 		Limit:      limit,
 		Offset:     offset,
 		Results:    books.Total,
 		TimeTaken:  latency,
 		Books:      books.Items,
+		Facets:     books.Facets,
+		Highlights: books.Highlights,
+		Sort:       query.Sort,
+		SortOrders: booksing.SortOrders,
 		Error:      err,
 		Q:          q,
+		Query:      query,
 		IsAdmin:    c.GetBool("isAdmin"),
 		TotalBooks: app.db.GetBookCount(),
 		Indexing:   app.state == "indexing",
@@ -136,41 +153,30 @@ func (app *booksingApp) deleteBook(c *gin.Context) {
 		return
 	}
 
-	err = os.Remove(book.Path)
+	u := c.MustGet("id")
+	user := u.(*booksing.User)
+
+	err = app.trashBook(book, user.Name)
 	if err != nil {
 		app.logger.WithFields(logrus.Fields{
 			"hash": hash,
 			"err":  err,
 			"path": book.Path,
-		}).Error("Could not delete book from filesystem")
+		}).Error("Could not move book to trash")
 		c.HTML(500, "error.html", V{
-			Error: fmt.Errorf("Unable to delete book from filesystem: %w", err),
+			Error: err,
 		})
 		return
 	}
 
-	err = app.db.DeleteBook(hash)
-	if err != nil {
-		app.logger.WithFields(logrus.Fields{
-			"hash": hash,
-			"err":  err,
-		}).Error("Could not delete book from database")
-		c.HTML(500, "error.html", V{
-			Error: fmt.Errorf("Unable to delete book from database: %w", err),
-		})
-		return
-	}
-	err = app.db.UpdateBookCount(-1)
-	if err != nil {
-		app.logger.WithFields(logrus.Fields{
-			"hash": hash,
-			"err":  err,
-		}).Error("could not update book count")
-	}
 	app.logger.WithFields(logrus.Fields{
 		"hash": hash,
-	}).Info("book was deleted")
-	c.Redirect(302, c.Request.Referer())
+	}).Info("book was moved to trash")
+	next := c.PostForm("next")
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") {
+		next = c.Request.Referer()
+	}
+	c.Redirect(302, next)
 }
 
 func (app *booksingApp) showDownloads(c *gin.Context) {
@@ -200,26 +206,12 @@ func (app *booksingApp) rotateIcon(c *gin.Context) {
 	u := c.MustGet("id")
 	user := u.(*booksing.User)
 
-	currentIcon := booksing.DefaultShelveIcon()
-
-	bm, ok := user.Bookmarks[hash]
-	if ok {
-		currentIcon = bm.Icon
-	}
-
-	newIcon, err := booksing.NextShelveIcon(currentIcon)
+	newIcon, err := booksing.NextShelveIcon(user.Icon(hash))
 	if err != nil {
 		newIcon = booksing.DefaultShelveIcon()
 	}
 
-	user.Bookmarks[hash] = booksing.Bookmark{
-		Icon:       newIcon,
-		LastChange: time.Now(),
-	}
-
-	if newIcon == booksing.DefaultShelveIcon() {
-		delete(user.Bookmarks, hash)
-	}
+	user.SetShelf(hash, newIcon)
 
 	err = app.db.SaveUser(user)
 	if err != nil {
@@ -242,16 +234,18 @@ func (app *booksingApp) rotateIcon(c *gin.Context) {
 func (app *booksingApp) bookmarks(c *gin.Context) {
 	u := c.MustGet("id")
 	user := u.(*booksing.User)
-	var books []booksing.Book
-
 	start := time.Now()
 
+	var hashes []string
 	for hash := range user.Bookmarks {
-		b, err := app.db.GetBook(hash)
-		if err != nil {
-			continue
-		}
-		books = append(books, *b)
+		hashes = append(hashes, hash)
+	}
+	books, err := app.db.GetBooksByHash(hashes)
+	if err != nil {
+		c.HTML(500, "error.html", V{
+			Error: err,
+		})
+		return
 	}
 
 	stop := time.Since(start)
@@ -275,29 +269,6 @@ func (app *booksingApp) serveIcon(c *gin.Context) {
 
 	hash = hash[:len(hash)-4]
 
-	currentIcon := booksing.DefaultShelveIcon()
-	bm, ok := user.Bookmarks[hash]
-	if ok {
-		currentIcon = bm.Icon
-	}
-
-	c.Redirect(http.StatusFound, fmt.Sprintf("/static/%s.png", currentIcon))
-
-}
-
-func (app *booksingApp) getUserIcon(username, hash string) (icon booksing.ShelveIcon, err error) {
-	icon = booksing.DefaultShelveIcon()
-
-	user, err := app.db.GetUser(username)
-	if err != nil {
-		app.logger.WithError(err).Warning("unable to get user from db")
-		return
-	}
-
-	bm, ok := user.Bookmarks[hash]
-	if ok {
-		icon = bm.Icon
-	}
+	c.Redirect(http.StatusFound, fmt.Sprintf("/static/%s.png", user.Icon(hash)))
 
-	return
 }
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+
+	"github.com/gnur/booksing"
+	"github.com/sirupsen/logrus"
+)
+
+const hashStrategySetting = "hashstrategy"
+
+// rehashPlan holds the result of recomputing the hashes of the library
+type rehashPlan struct {
+	books   []booksing.Book
+	groups  map[string][]int
+	changed int
+	merges  [][]int
+	splits  map[int][]string
+}
+
+// libraryHashStrategy returns the strategy the stored hashes were computed
+// with, a library without a stored strategy predates versioning and uses v1
+func libraryHashStrategy(db database, configured booksing.HashStrategy) (booksing.HashStrategy, error) {
+	var stored string
+	err := db.GetSetting(hashStrategySetting, &stored)
+	if err == booksing.ErrNotFound {
+		empty, err := emptyLibrary(db)
+		if err != nil {
+			return configured, err
+		}
+		if empty {
+			return configured, db.SaveSetting(hashStrategySetting, configured.String())
+		}
+		stored = booksing.DefaultHashStrategy.String()
+	} else if err != nil {
+		return configured, err
+	}
+	return booksing.ParseHashStrategy(stored)
+}
+
+// emptyLibrary reports whether no book has been stored yet, the book count
+// can't tell because it is missing on a new install
+func emptyLibrary(db database) (bool, error) {
+	hashes, err := db.GetHashes()
+	if err != nil {
+		return false, fmt.Errorf("Unable to get hashes: %w", err)
+	}
+	return len(hashes) == 0, nil
+}
+
+// rehash is the rehash command, it recomputes the hash of every book with
+// the configured strategy and reports which books would be merged or split,
+// with -apply the books are merged and the new strategy is stored
+func (app *booksingApp) rehash(args []string) error {
+	flags := flag.NewFlagSet("rehash", flag.ContinueOnError)
+	apply := flags.Bool("apply", false, "merge books and store the new hashes")
+	strategy := flags.String("strategy", app.cfg.HashStrategy, "hash strategy to migrate to")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	target, err := booksing.ParseHashStrategy(*strategy)
+	if err != nil {
+		return err
+	}
+
+	plan, err := app.planRehash(target)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("migrating %d books from %s to %s\n", len(plan.books), app.hasher, target)
+	fmt.Printf("%d books get a new hash\n", plan.changed)
+	fmt.Printf("%d groups of books will be merged:\n", len(plan.merges))
+	for _, group := range plan.merges {
+		for _, i := range group {
+			b := plan.books[i]
+			fmt.Printf("  %s - %s (%s)\n", b.Author, b.Title, b.Hash)
+		}
+		fmt.Println()
+	}
+	fmt.Printf("%d books have files that no longer share a hash, they are not split:\n", len(plan.splits))
+	for i, hashes := range plan.splits {
+		b := plan.books[i]
+		fmt.Printf("  %s - %s (%s): %v\n", b.Author, b.Title, b.Hash, hashes)
+	}
+
+	if !*apply {
+		fmt.Println("nothing was changed, run with -apply to migrate the library")
+		return nil
+	}
+
+	err = app.applyRehash(plan, target)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("library now uses %s\n", target)
+	return nil
+}
+
+func (app *booksingApp) planRehash(target booksing.HashStrategy) (*rehashPlan, error) {
+	books, err := app.db.GetAllBooks()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get books from db: %w", err)
+	}
+	sort.Slice(books, func(i, j int) bool {
+		return books[i].Added.Before(books[j].Added)
+	})
+
+	plan := rehashPlan{
+		books:  books,
+		groups: make(map[string][]int),
+		splits: make(map[int][]string),
+	}
+	for i := range books {
+		b := &books[i]
+		h := target.Hash(b)
+		if h != b.Hash {
+			plan.changed++
+		}
+		plan.groups[h] = append(plan.groups[h], i)
+
+		seen := map[string]bool{h: true}
+		for _, f := range b.Files {
+			if f.Title == "" {
+				continue
+			}
+			fh := target.HashFile(b, f)
+			if !seen[fh] {
+				seen[fh] = true
+				plan.splits[i] = append(plan.splits[i], fh)
+			}
+		}
+		if len(plan.splits[i]) > 0 {
+			plan.splits[i] = append([]string{h}, plan.splits[i]...)
+		}
+	}
+	for _, group := range plan.groups {
+		if len(group) > 1 {
+			plan.merges = append(plan.merges, group)
+		}
+	}
+	return &plan, nil
+}
+
+// applyRehash stores every book under its new hash, books that share a new
+// hash are merged into the book that was added first
+func (app *booksingApp) applyRehash(plan *rehashPlan, target booksing.HashStrategy) error {
+	renamed := make(map[string]string)
+	var rehashed []booksing.Book
+	merged := 0
+	for h, group := range plan.groups {
+		keep := plan.books[group[0]]
+		if len(group) == 1 && keep.Hash == h {
+			continue
+		}
+
+		for _, i := range group[1:] {
+			for _, f := range plan.books[i].AllFiles() {
+				err := keep.AttachFile(f, app.library)
+				if err != nil {
+					return fmt.Errorf("Unable to attach file: %w", err)
+				}
+			}
+		}
+		for _, i := range group {
+			renamed[plan.books[i].Hash] = h
+		}
+		keep.Hash = h
+		rehashed = append(rehashed, keep)
+		merged += len(group) - 1
+	}
+
+	err := app.db.RenameHashes(renamed)
+	if err != nil {
+		return fmt.Errorf("Unable to rename hashes: %w", err)
+	}
+	err = app.renameBookmarks(renamed)
+	if err != nil {
+		return err
+	}
+
+	// every new book is stored before the old ones are removed, so nothing
+	// is lost when this stops halfway. A new hash can be the old hash of
+	// another book, storing the new book already replaced that one.
+	current := make(map[string]bool)
+	for _, b := range rehashed {
+		err = app.db.AddBooks([]booksing.Book{b}, true)
+		if err != nil {
+			return fmt.Errorf("Unable to store book: %w", err)
+		}
+		err = app.db.AddHash(b.Hash)
+		if err != nil {
+			return fmt.Errorf("Unable to store hash: %w", err)
+		}
+		app.suggest.Add(b)
+		current[b.Hash] = true
+	}
+	for old := range renamed {
+		if current[old] {
+			continue
+		}
+		err = app.db.DeleteBook(old)
+		if err != nil {
+			return fmt.Errorf("Unable to delete book: %w", err)
+		}
+		app.suggest.Remove(old)
+	}
+
+	if merged > 0 {
+		err = app.db.UpdateBookCount(-merged)
+		if err != nil {
+			return fmt.Errorf("Unable to update book count: %w", err)
+		}
+	}
+
+	err = app.db.SaveSetting(hashStrategySetting, target.String())
+	if err != nil {
+		return fmt.Errorf("Unable to store hash strategy: %w", err)
+	}
+	app.hasher = target
+
+	app.logger.WithFields(logrus.Fields{
+		"strategy": target.String(),
+		"rehashed": len(rehashed),
+		"merged":   merged,
+	}).Info("rehashed library")
+	return nil
+}
+
+// renameBookmarks points the bookmarks of all users to the new hashes
+func (app *booksingApp) renameBookmarks(renamed map[string]string) error {
+	users, err := app.db.GetUsers()
+	if err != nil {
+		return fmt.Errorf("Unable to get users from db: %w", err)
+	}
+	for _, u := range users {
+		changed := false
+		bookmarks := make(map[string]booksing.Bookmark, len(u.Bookmarks))
+		for old, bm := range u.Bookmarks {
+			h := old
+			if n, ok := renamed[old]; ok && n != old {
+				h = n
+				changed = true
+			}
+			if existing, ok := bookmarks[h]; ok && existing.LastChange.After(bm.LastChange) {
+				continue
+			}
+			bookmarks[h] = bm
+		}
+		if !changed {
+			continue
+		}
+		u.Bookmarks = bookmarks
+		err = app.db.SaveUser(&u)
+		if err != nil {
+			return fmt.Errorf("Unable to update bookmarks: %w", err)
+		}
+	}
+	return nil
+}
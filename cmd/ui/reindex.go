@@ -0,0 +1,32 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/gin-gonic/gin"
+)
+
+// showReindex returns the progress of the search index rebuild
+func (app *booksingApp) showReindex(c *gin.Context) {
+	c.JSON(200, app.db.GetIndexProgress())
+}
+
+// runReindex rebuilds the search index in the background, searches keep
+// using the current index until the new one is swapped in
+func (app *booksingApp) runReindex(c *gin.Context) {
+	if app.db.GetIndexProgress().Running {
+		c.HTML(409, "error.html", V{
+			Error: errors.New("The search index is already being rebuilt"),
+		})
+		return
+	}
+
+	go func() {
+		err := app.db.RebuildIndex()
+		if err != nil {
+			app.logger.WithError(err).Error("search index rebuild failed")
+		}
+	}()
+
+	c.Redirect(302, "/admin/fsck")
+}
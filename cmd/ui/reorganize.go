@@ -0,0 +1,283 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gnur/booksing"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	pathTemplateSetting = "pathtemplate"
+	reorganizeSetting   = "reorganize"
+)
+
+var (
+	reorganizeLocker = stateUnlocked
+)
+
+// libraryView holds the naming template and a preview of a new template
+type libraryView struct {
+	Template   string
+	Organized  string
+	Fields     []string
+	Preview    string
+	Error      error
+	Samples    []pathSample
+	Collisions []pathCollision
+	Last       *booksing.ReorganizeResult
+}
+
+type pathSample struct {
+	Book booksing.Book
+	Path string
+}
+
+type pathCollision struct {
+	Path  string
+	Books []booksing.Book
+}
+
+// organizedTemplate returns the layout the files in the library match, a
+// library without a stored layout uses the default template
+func (app *booksingApp) organizedTemplate() (string, error) {
+	var organized string
+	err := app.db.GetSetting(pathTemplateSetting, &organized)
+	if err == booksing.ErrNotFound {
+		empty, err := emptyLibrary(app.db)
+		if err != nil {
+			return "", err
+		}
+		if empty {
+			organized = app.library.Layout()
+			return organized, app.db.SaveSetting(pathTemplateSetting, organized)
+		}
+		return booksing.DefaultPathTemplate, nil
+	}
+	return organized, err
+}
+
+// reorganizeIfChanged moves all files when the template has changed since
+// the library was last organized
+func (app *booksingApp) reorganizeIfChanged() {
+	organized, err := app.organizedTemplate()
+	if err != nil {
+		app.logger.WithError(err).Error("could not get template of library")
+		return
+	}
+	if organized == app.library.Layout() {
+		return
+	}
+	app.logger.WithFields(logrus.Fields{
+		"from": organized,
+		"to":   app.library.Layout(),
+	}).Info("path template changed, reorganizing library")
+
+	_, err = app.reorganize()
+	if err != nil {
+		app.logger.WithError(err).Error("reorganizing library failed")
+	}
+}
+
+// reorganize moves every file in the library to the location the template
+// gives it
+func (app *booksingApp) reorganize() (*booksing.ReorganizeResult, error) {
+	if !atomic.CompareAndSwapUint32(&reorganizeLocker, stateUnlocked, stateLocked) {
+		return nil, errors.New("library is already being reorganized")
+	}
+	defer atomic.StoreUint32(&reorganizeLocker, stateUnlocked)
+
+	result := booksing.ReorganizeResult{
+		Template:  app.library.Layout(),
+		StartTime: time.Now().In(app.timezone),
+	}
+
+	books, err := app.db.GetAllBooks()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get books from db: %w", err)
+	}
+	result.Books = len(books)
+
+	for i := range books {
+		b := &books[i]
+		changed := false
+		for j, f := range b.AllFiles() {
+			target := app.library.Path(b, j, f)
+			if target == f.Path {
+				continue
+			}
+			moved, err := booksing.MoveFile(f.Path, target)
+			if err != nil {
+				app.logger.WithFields(logrus.Fields{
+					"file":   f.Path,
+					"target": target,
+					"err":    err,
+				}).Warning("unable to move file")
+				result.Failed++
+				continue
+			}
+			if moved == f.Path {
+				continue
+			}
+			app.removeEmptyDirs(filepath.Dir(f.Path))
+
+			if j == 0 {
+				b.Path = moved
+			} else {
+				b.Files[j-1].Path = moved
+			}
+			result.Moved++
+			changed = true
+		}
+		if !changed {
+			continue
+		}
+		err = app.db.AddBooks([]booksing.Book{*b}, true)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to store book: %w", err)
+		}
+	}
+
+	result.StopTime = time.Now().In(app.timezone)
+	err = app.db.SaveSetting(reorganizeSetting, result)
+	if err != nil {
+		app.logger.WithError(err).Error("could not store reorganize result")
+	}
+	if result.Failed == 0 {
+		err = app.db.SaveSetting(pathTemplateSetting, result.Template)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to store template: %w", err)
+		}
+	}
+
+	app.logger.WithFields(logrus.Fields{
+		"books":     result.Books,
+		"moved":     result.Moved,
+		"failed":    result.Failed,
+		"timetaken": result.StopTime.Sub(result.StartTime).String(),
+	}).Info("finished reorganizing library")
+	return &result, nil
+}
+
+// removeEmptyDirs removes dir and its parents as long as they are empty and
+// inside the book dir
+func (app *booksingApp) removeEmptyDirs(dir string) {
+	for isWithin(app.bookDir, dir) && !isWithin(dir, app.bookDir) {
+		if os.Remove(dir) != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// planPaths renders the location of every book with t, it returns a few
+// samples and all paths that more than one book would end up at
+func (app *booksingApp) planPaths(t *booksing.PathTemplate) ([]pathSample, []pathCollision, error) {
+	books, err := app.db.GetAllBooks()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Unable to get books from db: %w", err)
+	}
+	lib := booksing.Library{
+		Dir:      app.bookDir,
+		Template: t,
+		Unicode:  app.library.Unicode,
+	}
+
+	var samples []pathSample
+	paths := make(map[string][]booksing.Book)
+	for i := range books {
+		b := &books[i]
+		p := lib.Path(b, 0, b.PrimaryFile())
+		paths[p] = append(paths[p], *b)
+		if len(samples) < 10 {
+			samples = append(samples, pathSample{
+				Book: *b,
+				Path: p,
+			})
+		}
+	}
+
+	var collisions []pathCollision
+	for p, colliding := range paths {
+		if len(colliding) > 1 {
+			collisions = append(collisions, pathCollision{
+				Path:  p,
+				Books: colliding,
+			})
+		}
+	}
+	sort.Slice(collisions, func(i, j int) bool {
+		return collisions[i].Path < collisions[j].Path
+	})
+	return samples, collisions, nil
+}
+
+func (app *booksingApp) showLibrary(c *gin.Context) {
+	organized, err := app.organizedTemplate()
+	if err != nil {
+		c.HTML(500, "error.html", V{
+			Error: err,
+		})
+		return
+	}
+
+	view := libraryView{
+		Template:  app.library.Layout(),
+		Organized: organized,
+		Fields:    booksing.PathFields,
+		Preview:   c.DefaultQuery("template", app.library.Template.String()),
+	}
+
+	var last booksing.ReorganizeResult
+	err = app.db.GetSetting(reorganizeSetting, &last)
+	if err == nil {
+		view.Last = &last
+	}
+
+	t, err := booksing.ParsePathTemplate(view.Preview)
+	if err != nil {
+		view.Error = err
+	} else {
+		view.Samples, view.Collisions, err = app.planPaths(t)
+		if err != nil {
+			c.HTML(500, "error.html", V{
+				Error: err,
+			})
+			return
+		}
+	}
+
+	c.HTML(200, "library.html", V{
+		Q:          "",
+		IsAdmin:    c.GetBool("isAdmin"),
+		TotalBooks: app.db.GetBookCount(),
+		Library:    &view,
+		Checking:   atomic.LoadUint32(&reorganizeLocker) == stateLocked,
+		Indexing:   app.state == "indexing",
+	})
+}
+
+func (app *booksingApp) runReorganize(c *gin.Context) {
+	if atomic.LoadUint32(&reorganizeLocker) == stateLocked {
+		c.HTML(409, "error.html", V{
+			Error: errors.New("The library is already being reorganized"),
+		})
+		return
+	}
+
+	go func() {
+		_, err := app.reorganize()
+		if err != nil {
+			app.logger.WithError(err).Error("reorganizing library failed")
+		}
+	}()
+
+	c.Redirect(302, "/admin/library")
+}
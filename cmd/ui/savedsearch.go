@@ -0,0 +1,268 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gnur/booksing"
+	"github.com/sirupsen/logrus"
+)
+
+// savedSearchView is a saved search with the number of books added since the
+// user last opened it
+type savedSearchView struct {
+	booksing.SavedSearch
+	New   int
+	Error error
+}
+
+// userQuery parses a search query, the books on the shelves of user are
+// filled in for shelf terms
+func userQuery(s string, user *booksing.User) (*booksing.Query, error) {
+	query, err := booksing.ParseQuery(s)
+	if err != nil {
+		return nil, err
+	}
+	for i := range query.Terms {
+		t := &query.Terms[i]
+		if t.Field == "shelf" {
+			t.Hashes = user.Shelf(booksing.ShelveIcon(t.Value))
+		}
+	}
+	return query, nil
+}
+
+func (app *booksingApp) showSavedSearches(c *gin.Context) {
+	user := c.MustGet("id").(*booksing.User)
+
+	searches, err := app.db.GetSavedSearches(user.Name)
+	if err != nil {
+		c.HTML(500, "error.html", V{
+			Error: err,
+		})
+		return
+	}
+	var views []savedSearchView
+	for _, s := range searches {
+		view := savedSearchView{SavedSearch: s}
+		query, err := userQuery(s.Query, user)
+		if err == nil {
+			view.New, err = app.db.CountBooks(query.Since(s.LastVisit))
+		}
+		view.Error = err
+		views = append(views, view)
+	}
+
+	notifications, err := app.db.GetNotifications(user.Name, 50)
+	if err != nil {
+		c.HTML(500, "error.html", V{
+			Error: err,
+		})
+		return
+	}
+
+	c.HTML(200, "searches.html", V{
+		Q:             "",
+		IsAdmin:       c.GetBool("isAdmin"),
+		TotalBooks:    app.db.GetBookCount(),
+		Indexing:      app.state == "indexing",
+		SavedSearches: views,
+		Notifications: notifications,
+	})
+
+	err = app.db.MarkNotificationsRead(user.Name)
+	if err != nil {
+		app.logger.WithError(err).Error("could not mark notifications as read")
+	}
+}
+
+func (app *booksingApp) saveSearch(c *gin.Context) {
+	user := c.MustGet("id").(*booksing.User)
+	q := c.PostForm("q")
+	name := c.PostForm("name")
+	if name == "" {
+		name = q
+	}
+
+	query, err := booksing.ParseQuery(q)
+	if err == nil && query.IsEmpty() {
+		err = errors.New("An empty search can't be saved")
+	}
+	if err != nil {
+		c.HTML(400, "error.html", V{
+			Error: err,
+		})
+		return
+	}
+
+	err = app.db.SaveSearch(&booksing.SavedSearch{
+		User:      user.Name,
+		Name:      name,
+		Query:     q,
+		Created:   time.Now(),
+		LastVisit: time.Now(),
+	})
+	if err != nil {
+		c.HTML(500, "error.html", V{
+			Error: err,
+		})
+		return
+	}
+	c.Redirect(302, "/searches")
+}
+
+// savedSearch returns the saved search in the url if it belongs to the user
+func (app *booksingApp) savedSearch(c *gin.Context) (*booksing.SavedSearch, error) {
+	user := c.MustGet("id").(*booksing.User)
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return nil, booksing.ErrNotFound
+	}
+	s, err := app.db.GetSavedSearch(id)
+	if err != nil {
+		return nil, err
+	}
+	if s.User != user.Name {
+		return nil, booksing.ErrNotFound
+	}
+	return s, nil
+}
+
+// openSavedSearch runs a saved search and resets its count of new books
+func (app *booksingApp) openSavedSearch(c *gin.Context) {
+	s, err := app.savedSearch(c)
+	if err != nil {
+		c.HTML(404, "error.html", V{
+			Error: err,
+		})
+		return
+	}
+
+	s.LastVisit = time.Now()
+	err = app.db.SaveSearch(s)
+	if err != nil {
+		app.logger.WithError(err).Error("could not update saved search")
+	}
+	c.Redirect(302, "/?q="+url.QueryEscape(s.Query))
+}
+
+func (app *booksingApp) deleteSavedSearch(c *gin.Context) {
+	s, err := app.savedSearch(c)
+	if err != nil {
+		c.HTML(404, "error.html", V{
+			Error: err,
+		})
+		return
+	}
+
+	err = app.db.DeleteSavedSearch(s.ID)
+	if err != nil {
+		c.HTML(500, "error.html", V{
+			Error: err,
+		})
+		return
+	}
+	c.Redirect(302, "/searches")
+}
+
+// unreadNotifications returns the number of notifications the user hasn't
+// seen yet
+func (app *booksingApp) unreadNotifications(c *gin.Context) {
+	user := c.MustGet("id").(*booksing.User)
+	count, err := app.db.CountUnreadNotifications(user.Name)
+	if err != nil {
+		c.JSON(500, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	c.JSON(200, gin.H{
+		"unread": count,
+	})
+}
+
+// notifySavedSearches notifies the owners of saved searches that match the
+// books that were just indexed. Books that were imported before the search
+// was saved are updates of existing books and are skipped.
+func (app *booksingApp) notifySavedSearches(books []booksing.Book) {
+	searches, err := app.db.GetSavedSearches("")
+	if err != nil {
+		app.logger.WithError(err).Error("could not get saved searches")
+		return
+	}
+	if len(searches) == 0 {
+		return
+	}
+
+	var hashes []string
+	byHash := make(map[string]booksing.Book)
+	for _, b := range books {
+		hashes = append(hashes, b.Hash)
+		byHash[b.Hash] = b
+	}
+
+	users := make(map[string]*booksing.User)
+	for _, s := range searches {
+		user, ok := users[s.User]
+		if !ok {
+			u, err := app.db.GetUser(s.User)
+			if err != nil {
+				app.logger.WithError(err).WithField("user", s.User).Warning("could not get user of saved search")
+				continue
+			}
+			user = &u
+			users[s.User] = user
+		}
+		query, err := userQuery(s.Query, user)
+		if err != nil {
+			continue
+		}
+		matches, err := app.db.MatchBooks(query, hashes)
+		if err != nil {
+			app.logger.WithError(err).WithField("search", s.ID).Error("could not match saved search")
+			continue
+		}
+
+		for _, hash := range matches {
+			b := byHash[hash]
+			if b.Imported.Before(s.Created) {
+				continue
+			}
+			n := booksing.NewNotification(s, b)
+			added, err := app.db.AddNotification(n)
+			if err != nil {
+				app.logger.WithError(err).Error("could not store notification")
+				continue
+			}
+			if !added {
+				continue
+			}
+			app.logger.WithFields(logrus.Fields{
+				"user":   s.User,
+				"search": s.Name,
+				"book":   hash,
+			}).Info("new book matches saved search")
+
+			if app.cfg.MQTTEnabled {
+				e, err := newEvent("booksing", "xyz.dekeijzer.booksing.match", map[string]string{
+					"user":   s.User,
+					"search": s.Name,
+					"query":  s.Query,
+					"book":   hash,
+					"title":  b.Title,
+					"author": b.Author,
+				})
+				if err != nil {
+					app.logger.WithField("err", err).Error("could not create match event")
+				}
+				err = app.pushEvent(e)
+				if err != nil {
+					app.logger.WithField("err", err).Error("could not push match event")
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gnur/booksing"
+	"github.com/sirupsen/logrus"
+)
+
+// tokens shared by more books than this are too common to find duplicates with
+const maxBlockSize = 200
+
+var (
+	similarLocker = stateUnlocked
+)
+
+// similarGroup holds a group of similar books for display
+type similarGroup struct {
+	Group booksing.SimilarGroup
+	Books []booksing.Book
+}
+
+func (app *booksingApp) similarityLoop() {
+	interval, err := time.ParseDuration(app.cfg.SimilarityInterval)
+	if err != nil {
+		app.logger.WithError(err).Error("invalid similarity interval, not looking for similar books")
+		return
+	}
+	for {
+		time.Sleep(interval)
+		err := app.findSimilar()
+		if err != nil {
+			app.logger.WithError(err).Error("looking for similar books failed")
+		}
+	}
+}
+
+// findSimilar compares all books that share a word in their author or title,
+// a checksum or an ISBN and stores groups of books that are likely the
+// same work
+func (app *booksingApp) findSimilar() error {
+	if !atomic.CompareAndSwapUint32(&similarLocker, stateUnlocked, stateLocked) {
+		return errors.New("already looking for similar books")
+	}
+	defer atomic.StoreUint32(&similarLocker, stateUnlocked)
+
+	start := time.Now()
+	books, err := app.db.GetAllBooks()
+	if err != nil {
+		return fmt.Errorf("Unable to get books from db: %w", err)
+	}
+
+	blocks := make(map[string][]int)
+	for i, b := range books {
+		seen := make(map[string]bool)
+		for _, token := range strings.Fields(booksing.Normalize(b.Author + " " + b.Title)) {
+			if len(token) < 4 || seen[token] {
+				continue
+			}
+			seen[token] = true
+			blocks[token] = append(blocks[token], i)
+		}
+		for _, sum := range b.Checksums() {
+			blocks["sha256:"+sum] = append(blocks["sha256:"+sum], i)
+		}
+		for _, id := range b.Identifiers {
+			if isbn := booksing.ISBN(id); isbn != "" {
+				blocks["isbn:"+isbn] = append(blocks["isbn:"+isbn], i)
+			}
+		}
+	}
+
+	type pairScore struct {
+		score   float64
+		reasons []string
+	}
+	compared := make(map[[2]int]bool)
+	scores := make(map[int]pairScore)
+	parent := make([]int, len(books))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	for _, block := range blocks {
+		if len(block) > maxBlockSize {
+			continue
+		}
+		for x := 0; x < len(block); x++ {
+			for y := x + 1; y < len(block); y++ {
+				pair := [2]int{block[x], block[y]}
+				if compared[pair] {
+					continue
+				}
+				compared[pair] = true
+
+				score, reasons := booksing.CompareBooks(&books[pair[0]], &books[pair[1]])
+				if score < app.cfg.SimilarityThreshold {
+					continue
+				}
+				a, b := find(pair[0]), find(pair[1])
+				parent[a] = b
+				best := scores[b]
+				if other, ok := scores[a]; ok && other.score > best.score {
+					best = other
+				}
+				if score > best.score {
+					best = pairScore{score, reasons}
+				}
+				scores[b] = best
+			}
+		}
+	}
+
+	members := make(map[int][]string)
+	for i := range books {
+		root := find(i)
+		members[root] = append(members[root], books[i].Hash)
+	}
+
+	existing, err := app.db.GetSimilarGroups()
+	if err != nil {
+		return fmt.Errorf("Unable to get similar groups from db: %w", err)
+	}
+	dismissed := make(map[string]bool)
+	for _, g := range existing {
+		if g.Dismissed {
+			dismissed[g.ID] = true
+			continue
+		}
+		err = app.db.DeleteSimilarGroup(g.ID)
+		if err != nil {
+			return fmt.Errorf("Unable to delete similar group: %w", err)
+		}
+	}
+
+	found := 0
+	for root, hashes := range members {
+		if len(hashes) < 2 {
+			continue
+		}
+		id := booksing.GroupID(hashes)
+		if dismissed[id] {
+			continue
+		}
+		err = app.db.SaveSimilarGroup(&booksing.SimilarGroup{
+			ID:      id,
+			Hashes:  hashes,
+			Score:   scores[root].score,
+			Reasons: scores[root].reasons,
+			Found:   time.Now().In(app.timezone),
+		})
+		if err != nil {
+			return fmt.Errorf("Unable to store similar group: %w", err)
+		}
+		found++
+	}
+
+	app.logger.WithFields(logrus.Fields{
+		"books":     len(books),
+		"compared":  len(compared),
+		"groups":    found,
+		"timetaken": time.Since(start).String(),
+	}).Info("finished looking for similar books")
+	return nil
+}
+
+func (app *booksingApp) showSimilar(c *gin.Context) {
+	groups, err := app.db.GetSimilarGroups()
+	if err != nil {
+		c.HTML(500, "error.html", V{
+			Error: err,
+		})
+		c.Abort()
+		return
+	}
+
+	var similar []similarGroup
+	for _, g := range groups {
+		if g.Dismissed {
+			continue
+		}
+		group := similarGroup{
+			Group: g,
+		}
+		for _, h := range g.Hashes {
+			b, err := app.db.GetBook(h)
+			if err != nil {
+				continue
+			}
+			group.Books = append(group.Books, *b)
+		}
+		if len(group.Books) < 2 {
+			continue
+		}
+		similar = append(similar, group)
+	}
+
+	c.HTML(200, "similar.html", V{
+		Q:          "",
+		IsAdmin:    c.GetBool("isAdmin"),
+		TotalBooks: app.db.GetBookCount(),
+		Similar:    similar,
+		Checking:   atomic.LoadUint32(&similarLocker) == stateLocked,
+		Indexing:   app.state == "indexing",
+	})
+}
+
+func (app *booksingApp) runSimilar(c *gin.Context) {
+	go func() {
+		err := app.findSimilar()
+		if err != nil {
+			app.logger.WithError(err).Error("looking for similar books failed")
+		}
+	}()
+	c.Redirect(302, "/admin/similar")
+}
+
+// mergeSimilar attaches the files of all books in a group to the book that
+// is kept and removes the other books from the library
+func (app *booksingApp) mergeSimilar(c *gin.Context) {
+	group, ok := app.getSimilarGroup(c)
+	if !ok {
+		return
+	}
+	keep := c.PostForm("keep")
+	inGroup := false
+	for _, h := range group.Hashes {
+		inGroup = inGroup || h == keep
+	}
+	if !inGroup {
+		c.HTML(400, "error.html", V{
+			Error: errors.New("Book to keep is not part of this group"),
+		})
+		return
+	}
+
+	unlock := app.bookLocks.LockAll(group.Hashes...)
+	defer unlock()
+
+	book, err := app.db.GetBook(keep)
+	if err != nil {
+		c.HTML(404, "error.html", V{
+			Error: errors.New("Book to keep not found"),
+		})
+		return
+	}
+
+	for _, h := range group.Hashes {
+		if h == keep {
+			continue
+		}
+		other, err := app.db.GetBook(h)
+		if err != nil {
+			continue
+		}
+		err = app.mergeInto(book, other)
+		if err != nil {
+			app.logger.WithFields(logrus.Fields{
+				"keep":  keep,
+				"merge": h,
+				"err":   err,
+			}).Error("Could not merge book")
+			c.HTML(500, "error.html", V{
+				Error: fmt.Errorf("Unable to merge book: %w", err),
+			})
+			return
+		}
+	}
+
+	err = app.db.DeleteSimilarGroup(group.ID)
+	if err != nil {
+		app.logger.WithError(err).Error("could not delete similar group")
+	}
+
+	app.logger.WithField("hash", keep).Info("merged similar books")
+	c.Redirect(302, c.Request.Referer())
+}
+
+// mergeInto attaches the files of other to book and removes other, its
+// bookmarks and downloads move to book. The caller holds the locks of both.
+func (app *booksingApp) mergeInto(book, other *booksing.Book) error {
+	for _, f := range other.AllFiles() {
+		err := book.AttachFile(f, app.library)
+		if err != nil {
+			return err
+		}
+	}
+	renamed := map[string]string{other.Hash: book.Hash}
+	err := app.db.RenameHashes(renamed)
+	if err != nil {
+		return fmt.Errorf("Unable to move downloads: %w", err)
+	}
+	err = app.renameBookmarks(renamed)
+	if err != nil {
+		return err
+	}
+	err = app.db.AddBooks([]booksing.Book{*book}, true)
+	if err != nil {
+		return err
+	}
+	err = app.db.DeleteBook(other.Hash)
+	if err != nil {
+		return err
+	}
+	app.suggest.Remove(other.Hash)
+
+	//keep the hash so new imports of the merged book are seen as duplicates
+	err = app.db.AddHash(other.Hash)
+	if err != nil {
+		return fmt.Errorf("Unable to store hash: %w", err)
+	}
+	err = app.db.AddMergedHash(other.Hash, book.Hash)
+	if err != nil {
+		return fmt.Errorf("Unable to store merged hash: %w", err)
+	}
+	return app.db.UpdateBookCount(-1)
+}
+
+func (app *booksingApp) dismissSimilar(c *gin.Context) {
+	group, ok := app.getSimilarGroup(c)
+	if !ok {
+		return
+	}
+
+	group.Dismissed = true
+	err := app.db.SaveSimilarGroup(group)
+	if err != nil {
+		c.HTML(500, "error.html", V{
+			Error: err,
+		})
+		return
+	}
+	c.Redirect(302, c.Request.Referer())
+}
+
+func (app *booksingApp) getSimilarGroup(c *gin.Context) (*booksing.SimilarGroup, bool) {
+	group, err := app.db.GetSimilarGroup(c.PostForm("id"))
+	if err == booksing.ErrNotFound {
+		c.HTML(404, "error.html", V{
+			Error: errors.New("Group of similar books not found"),
+		})
+		return nil, false
+	} else if err != nil {
+		c.HTML(500, "error.html", V{
+			Error: err,
+		})
+		return nil, false
+	}
+	return group, true
+}
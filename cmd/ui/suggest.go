@@ -0,0 +1,32 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxSuggestions = 10
+
+// loadSuggestions fills the suggestion index with all books in the library,
+// new and changed books are added by the searchUpdater
+func (app *booksingApp) loadSuggestions() {
+	books, err := app.db.GetAllBooks()
+	if err != nil {
+		app.logger.WithError(err).Error("could not load books for suggestions")
+		return
+	}
+	for _, b := range books {
+		app.suggest.Add(b)
+	}
+	app.logger.WithField("books", len(books)).Debug("loaded suggestions")
+}
+
+// suggestions returns authors, series and titles that complete the query
+func (app *booksingApp) suggestions(c *gin.Context) {
+	limit, err := strconv.Atoi(c.Query("l"))
+	if err != nil || limit <= 0 || limit > maxSuggestions {
+		limit = maxSuggestions
+	}
+	c.JSON(200, app.suggest.Suggest(c.Query("q"), limit))
+}
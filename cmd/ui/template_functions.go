@@ -7,13 +7,19 @@ import (
 	"net/url"
 	"reflect"
 	"sort"
+	"strings"
 	"time"
+
+	"github.com/gnur/booksing"
 )
 
 var templateFunctions = template.FuncMap{
 	"percent": func(a, b int) float64 {
 		return float64(a) / float64(b) * 100
 	},
+	"percent100": func(f float64) float64 {
+		return f * 100
+	},
 	"safeHTML": func(s interface{}) template.HTML {
 		return template.HTML(fmt.Sprint(s))
 	},
@@ -134,9 +140,10 @@ var templateFunctions = template.FuncMap{
 		}
 		return template.HTML(t.Format("2006-01-02 15:04:05"))
 	},
-	"page": func(dir, q string, offset, limit int64) template.URL {
+	"page": func(dir, q, sort string, offset, limit int64) template.URL {
 		v := url.Values{}
 		v.Add("q", q)
+		v.Add("s", sort)
 		v.Add("l", fmt.Sprintf("%v", limit))
 		if dir == "next" {
 			start := offset + limit
@@ -150,6 +157,33 @@ var templateFunctions = template.FuncMap{
 		return template.URL(v.Encode())
 
 	},
+	"filter": func(q *booksing.Query, filter, sort string, limit int64) template.URL {
+		return searchURL(q.WithFilter(filter), sort, limit)
+	},
+	"unfilter": func(q *booksing.Query, filter, sort string, limit int64) template.URL {
+		return searchURL(q.WithoutFilter(filter), sort, limit)
+	},
+	"paragraphs": func(s string) []string {
+		var paragraphs []string
+		for _, p := range strings.Split(s, "\n") {
+			if p = strings.TrimSpace(p); p != "" {
+				paragraphs = append(paragraphs, p)
+			}
+		}
+		return paragraphs
+	},
+	"fileSize": func(size int64) string {
+		const unit = 1024
+		if size < unit {
+			return fmt.Sprintf("%d B", size)
+		}
+		div, exp := int64(unit), 0
+		for n := size / unit; n >= unit; n /= unit {
+			div *= unit
+			exp++
+		}
+		return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+	},
 	"json": func(s interface{}) template.HTML {
 		json, _ := json.MarshalIndent(s, "", "  ")
 		return template.HTML(string(json))
@@ -192,3 +226,12 @@ var templateFunctions = template.FuncMap{
 		return template.HTML(fmt.Sprintf("%v%s %s", seconds, quantifier, tense))
 	},
 }
+
+// searchURL returns the query string of a search page
+func searchURL(q, sort string, limit int64) template.URL {
+	v := url.Values{}
+	v.Add("q", q)
+	v.Add("s", sort)
+	v.Add("l", fmt.Sprintf("%v", limit))
+	return template.URL(v.Encode())
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gnur/booksing"
+	"github.com/sirupsen/logrus"
+)
+
+var errBookExists = errors.New("A book with the same hash has been imported since it was deleted")
+
+func (app *booksingApp) trashLoop() {
+	for {
+		app.purgeExpiredTrash()
+		time.Sleep(time.Hour)
+	}
+}
+
+// trashBook moves the file of a book to the trash dir and removes the book
+// from every store, the book can be restored until it is purged.
+func (app *booksingApp) trashBook(book *booksing.Book, username string) error {
+	deleted := time.Now().In(app.timezone)
+	trashed := booksing.TrashedBook{
+		ID:        fmt.Sprintf("%s-%d", book.Hash, deleted.UnixNano()),
+		Hash:      book.Hash,
+		Book:      *book,
+		Deleted:   deleted,
+		DeletedBy: username,
+	}
+
+	err := os.MkdirAll(app.cfg.TrashDir, 0755)
+	if err != nil {
+		return fmt.Errorf("Unable to create trash dir: %w", err)
+	}
+
+	trashPath := filepath.Join(app.cfg.TrashDir, trashed.ID+filepath.Ext(book.Path))
+	err = os.Rename(book.Path, trashPath)
+	if err == nil {
+		trashed.TrashPath = trashPath
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("Unable to move book to trash: %w", err)
+	}
+
+	for i, f := range book.Files {
+		p := filepath.Join(app.cfg.TrashDir, fmt.Sprintf("%s-%d%s", trashed.ID, i+2, filepath.Ext(f.Path)))
+		err = os.Rename(f.Path, p)
+		if err != nil {
+			p = ""
+		}
+		trashed.TrashFiles = append(trashed.TrashFiles, p)
+	}
+
+	putBack := func() {
+		if trashed.TrashPath != "" {
+			_ = os.Rename(trashPath, book.Path)
+		}
+		for i, p := range trashed.TrashFiles {
+			if p != "" {
+				_ = os.Rename(p, book.Files[i].Path)
+			}
+		}
+	}
+
+	err = app.db.AddTrashedBook(trashed)
+	if err != nil {
+		putBack()
+		return fmt.Errorf("Unable to store trashed book: %w", err)
+	}
+
+	err = app.db.DeleteBook(book.Hash)
+	if err != nil {
+		//the book is still in the library, so its files go back too
+		putBack()
+		_ = app.db.DeleteTrashedBook(trashed.ID)
+		return fmt.Errorf("Unable to delete book from database: %w", err)
+	}
+	app.suggest.Remove(book.Hash)
+
+	err = app.db.UpdateBookCount(-1)
+	if err != nil {
+		app.logger.WithFields(logrus.Fields{
+			"hash": book.Hash,
+			"err":  err,
+		}).Error("could not update book count")
+	}
+	return nil
+}
+
+// restoreBook moves a trashed book back to its original location and adds it
+// to every store again.
+func (app *booksingApp) restoreBook(id string) error {
+	trashed, err := app.db.GetTrashedBook(id)
+	if err != nil {
+		return err
+	}
+	hash := trashed.Hash
+
+	exists, err := app.db.HasHash(hash)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return errBookExists
+	}
+
+	book := trashed.Book
+	if trashed.TrashPath == "" {
+		return errors.New("Book has no file to restore")
+	}
+	restored, err := booksing.MoveFile(trashed.TrashPath, book.Path)
+	if err != nil {
+		return fmt.Errorf("Unable to move book out of trash: %w", err)
+	}
+	book.Path = restored
+	var files []booksing.BookFile
+	for i, f := range book.Files {
+		if i >= len(trashed.TrashFiles) || trashed.TrashFiles[i] == "" {
+			continue
+		}
+		restored, err := booksing.MoveFile(trashed.TrashFiles[i], f.Path)
+		if err != nil {
+			app.logger.WithFields(logrus.Fields{
+				"hash": hash,
+				"file": f.Path,
+				"err":  err,
+			}).Warning("could not restore attached file")
+			continue
+		}
+		f.Path = restored
+		files = append(files, f)
+	}
+	book.Files = files
+
+	err = app.db.AddBooks([]booksing.Book{book}, true)
+	if err != nil {
+		return fmt.Errorf("Unable to add book to database: %w", err)
+	}
+	app.suggest.Add(book)
+	err = app.db.AddHash(book.Hash)
+	if err != nil {
+		return fmt.Errorf("Unable to store hash: %w", err)
+	}
+	err = app.db.UpdateBookCount(1)
+	if err != nil {
+		app.logger.WithFields(logrus.Fields{
+			"hash": hash,
+			"err":  err,
+		}).Error("could not update book count")
+	}
+
+	return app.db.DeleteTrashedBook(id)
+}
+
+// purgeBook permanently removes a trashed book
+func (app *booksingApp) purgeBook(id string) error {
+	trashed, err := app.db.GetTrashedBook(id)
+	if err != nil {
+		return err
+	}
+	for _, p := range append([]string{trashed.TrashPath}, trashed.TrashFiles...) {
+		if p == "" {
+			continue
+		}
+		err = os.Remove(p)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Unable to delete book from filesystem: %w", err)
+		}
+	}
+	return app.db.DeleteTrashedBook(id)
+}
+
+func (app *booksingApp) purgeExpiredTrash() {
+	retention, err := time.ParseDuration(app.cfg.TrashRetention)
+	if err != nil {
+		app.logger.WithError(err).Error("invalid trash retention, not purging trash")
+		return
+	}
+
+	trashed, err := app.db.GetTrashedBooks()
+	if err != nil {
+		app.logger.WithError(err).Error("could not get trashed books")
+		return
+	}
+
+	for _, t := range trashed {
+		if time.Since(t.Deleted) < retention {
+			continue
+		}
+		err = app.purgeBook(t.ID)
+		if err != nil {
+			app.logger.WithFields(logrus.Fields{
+				"hash": t.Hash,
+				"err":  err,
+			}).Error("could not purge book from trash")
+			continue
+		}
+		app.logger.WithField("hash", t.Hash).Info("purged expired book from trash")
+	}
+}
+
+func (app *booksingApp) showTrash(c *gin.Context) {
+	trashed, err := app.db.GetTrashedBooks()
+	if err != nil {
+		c.HTML(500, "error.html", V{
+			Error: err,
+		})
+		c.Abort()
+		return
+	}
+
+	c.HTML(200, "trash.html", V{
+		Q:          "",
+		IsAdmin:    c.GetBool("isAdmin"),
+		TotalBooks: app.db.GetBookCount(),
+		Trash:      trashed,
+		Indexing:   app.state == "indexing",
+	})
+}
+
+func (app *booksingApp) restoreTrashedBook(c *gin.Context) {
+	id := c.Param("id")
+
+	err := app.restoreBook(id)
+	if err == booksing.ErrNotFound {
+		c.HTML(404, "error.html", V{
+			Error: errors.New("Book not found in trash"),
+		})
+		return
+	} else if err != nil {
+		app.logger.WithFields(logrus.Fields{
+			"id":  id,
+			"err": err,
+		}).Error("Could not restore book")
+		c.HTML(500, "error.html", V{
+			Error: fmt.Errorf("Unable to restore book: %w", err),
+		})
+		return
+	}
+
+	app.logger.WithField("id", id).Info("book was restored")
+	c.Redirect(302, c.Request.Referer())
+}
+
+func (app *booksingApp) purgeTrashedBook(c *gin.Context) {
+	id := c.Param("id")
+
+	err := app.purgeBook(id)
+	if err == booksing.ErrNotFound {
+		c.HTML(404, "error.html", V{
+			Error: errors.New("Book not found in trash"),
+		})
+		return
+	} else if err != nil {
+		app.logger.WithFields(logrus.Fields{
+			"id":  id,
+			"err": err,
+		}).Error("Could not purge book")
+		c.HTML(500, "error.html", V{
+			Error: fmt.Errorf("Unable to purge book: %w", err),
+		})
+		return
+	}
+
+	app.logger.WithField("id", id).Info("book was purged")
+	c.Redirect(302, c.Request.Referer())
+}
@@ -12,6 +12,7 @@ type booksingApp struct {
 	db           database
 	mqttClient   mqtt.Client
 	bookDir      string
+	library      booksing.Library
 	importDir    string
 	logger       *logrus.Entry
 	timezone     *time.Location
@@ -22,6 +23,12 @@ type booksingApp struct {
 	resultQ      chan parseResult
 	searchQ      chan booksing.Book
 	saveInterval time.Duration
+	dupPolicy    booksing.DuplicatePolicy
+	hasher       booksing.HashStrategy
+	suggest      *booksing.SuggestIndex
+	bookLocks    hashLocks
+
+	importOptions booksing.ImportOptions
 }
 
 type parseResult int32
@@ -48,13 +55,73 @@ type database interface {
 	UpdateBookCount(int) error
 	GetBookCountHistory(time.Time, time.Time) ([]booksing.BookCount, error)
 
+	SetBookCount(int) error
+
+	GetSetting(string, interface{}) error
+	SaveSetting(string, interface{}) error
+
 	AddHash(string) error
 	HasHash(string) (bool, error)
+	DeleteHash(string) error
+	GetHashes() ([]string, error)
+	AddMergedHash(string, string) error
+	GetMergedHash(string) (string, error)
+
+	SaveFsckReport(*booksing.FsckReport) error
+	GetLastFsckReport(bool) (*booksing.FsckReport, error)
 
 	Close()
 
 	AddBooks([]booksing.Book, bool) error
 	GetBook(string) (*booksing.Book, error)
+	GetBooksByHash([]string) ([]booksing.Book, error)
+	GetDownloadCount(string) int
+	RenameHashes(map[string]string) error
+	GetRelatedBooks(string, []string, int) ([]booksing.Book, error)
+	RebuildIndex() error
+	GetIndexProgress() booksing.IndexProgress
+	GetBookByChecksum(string) (*booksing.Book, error)
+	ClaimChecksum(string, string) (string, bool, error)
 	DeleteBook(string) error
-	GetBooks(string, int64, int64) (*booksing.SearchResult, error)
+	GetBooks(*booksing.Query, int64, int64) (*booksing.SearchResult, error)
+	GetAllBooks() ([]booksing.Book, error)
+	GetIndexedHashes() ([]string, error)
+
+	AddTrashedBook(booksing.TrashedBook) error
+	GetTrashedBook(string) (*booksing.TrashedBook, error)
+	GetTrashedBooks() ([]booksing.TrashedBook, error)
+	DeleteTrashedBook(string) error
+
+	AddDuplicate(*booksing.Duplicate) error
+	GetDuplicate(int) (*booksing.Duplicate, error)
+	GetDuplicates() ([]booksing.Duplicate, error)
+	DeleteDuplicate(int) error
+
+	SaveAuthor(*booksing.Author) error
+	GetAuthor(string) (*booksing.Author, error)
+	GetAuthors() ([]booksing.Author, error)
+	DeleteAuthor(string) error
+	GetAuthorBookCounts() (map[string]int, error)
+	GetAuthorBooks(string) ([]booksing.Book, error)
+
+	SaveAuthorAlias(*booksing.AuthorAlias) error
+	GetAuthorAlias(string) (*booksing.AuthorAlias, error)
+	GetAuthorAliases() ([]booksing.AuthorAlias, error)
+	DeleteAuthorAlias(string) error
+
+	SaveSimilarGroup(*booksing.SimilarGroup) error
+	GetSimilarGroup(string) (*booksing.SimilarGroup, error)
+	GetSimilarGroups() ([]booksing.SimilarGroup, error)
+	DeleteSimilarGroup(string) error
+
+	SaveSearch(*booksing.SavedSearch) error
+	GetSavedSearch(int) (*booksing.SavedSearch, error)
+	GetSavedSearches(string) ([]booksing.SavedSearch, error)
+	DeleteSavedSearch(int) error
+	CountBooks(*booksing.Query) (int, error)
+	MatchBooks(*booksing.Query, []string) ([]string, error)
+	AddNotification(booksing.Notification) (bool, error)
+	GetNotifications(string, int) ([]booksing.Notification, error)
+	CountUnreadNotifications(string) (int, error)
+	MarkNotificationsRead(string) error
 }
@@ -0,0 +1,86 @@
+package booksing
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DuplicateRule is a single criterium used to choose between two files of the same book
+type DuplicateRule string
+
+// all possible duplicate rules
+const (
+	KeepFirst  DuplicateRule = "first"
+	KeepNewest DuplicateRule = "newest"
+	KeepLarger DuplicateRule = "larger"
+	KeepCover  DuplicateRule = "cover"
+	KeepValid  DuplicateRule = "valid"
+	KeepEpub3  DuplicateRule = "epub3"
+)
+
+// DuplicatePolicy is an ordered list of rules, the first rule that prefers
+// one of the files decides which one is kept
+type DuplicatePolicy []DuplicateRule
+
+// Duplicate is a file that lost from an existing book with the same hash,
+// it is kept so an admin can review it and swap it with the existing book
+type Duplicate struct {
+	ID        int    `storm:"id,increment"`
+	Hash      string `storm:"index"`
+	Candidate Book
+	Found     time.Time
+}
+
+// ParseDuplicatePolicy parses a comma separated list of rules
+func ParseDuplicatePolicy(s string) (DuplicatePolicy, error) {
+	var policy DuplicatePolicy
+	for _, part := range strings.Split(s, ",") {
+		rule := DuplicateRule(strings.ToLower(strings.TrimSpace(part)))
+		switch rule {
+		case "":
+			continue
+		case KeepFirst, KeepNewest, KeepLarger, KeepCover, KeepValid, KeepEpub3:
+			policy = append(policy, rule)
+		default:
+			return nil, fmt.Errorf("Unknown duplicate rule: %s", rule)
+		}
+	}
+	return policy, nil
+}
+
+// PreferNew reports whether the candidate should replace the existing book
+func (p DuplicatePolicy) PreferNew(existing, candidate *Book) bool {
+	for _, rule := range p {
+		switch rule {
+		case KeepFirst:
+			return false
+		case KeepNewest:
+			if !candidate.Added.Equal(existing.Added) {
+				return candidate.Added.After(existing.Added)
+			}
+		case KeepLarger:
+			if candidate.Size != existing.Size {
+				return candidate.Size > existing.Size
+			}
+		case KeepCover:
+			if candidate.HasCover != existing.HasCover {
+				return candidate.HasCover
+			}
+		case KeepValid:
+			if candidate.Valid != existing.Valid {
+				return candidate.Valid
+			}
+		case KeepEpub3:
+			if candidate.IsEpub3() != existing.IsEpub3() {
+				return candidate.IsEpub3()
+			}
+		}
+	}
+	return false
+}
+
+// IsEpub3 reports whether the book uses version 3 of the epub spec
+func (b *Book) IsEpub3() bool {
+	return strings.HasPrefix(b.EpubVersion, "3")
+}
@@ -0,0 +1,79 @@
+package booksing
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_preferNew(t *testing.T) {
+	now := time.Now()
+	existing := Book{
+		Added:       now,
+		Size:        2000,
+		EpubVersion: "2.0",
+		HasCover:    false,
+		Valid:       true,
+	}
+	candidate := Book{
+		Added:       now.Add(time.Hour),
+		Size:        1000,
+		EpubVersion: "3.0",
+		HasCover:    true,
+		Valid:       true,
+	}
+
+	tests := []struct {
+		name   string
+		policy string
+		want   bool
+	}{
+		{
+			name:   "empty policy keeps first",
+			policy: "",
+			want:   false,
+		},
+		{
+			name:   "keep first",
+			policy: "first,newest",
+			want:   false,
+		},
+		{
+			name:   "keep newest",
+			policy: "newest",
+			want:   true,
+		},
+		{
+			name:   "keep larger",
+			policy: "larger",
+			want:   false,
+		},
+		{
+			name:   "equal validity falls through to next rule",
+			policy: "valid, cover",
+			want:   true,
+		},
+		{
+			name:   "prefer epub3",
+			policy: "EPUB3,larger",
+			want:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := ParseDuplicatePolicy(tt.policy)
+			if err != nil {
+				t.Fatalf("ParseDuplicatePolicy() error = %v", err)
+			}
+			if got := p.PreferNew(&existing, &candidate); got != tt.want {
+				t.Errorf("PreferNew() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseDuplicatePolicyInvalid(t *testing.T) {
+	_, err := ParseDuplicatePolicy("first,smallest")
+	if err == nil {
+		t.Error("ParseDuplicatePolicy() expected error for unknown rule")
+	}
+}
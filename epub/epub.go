@@ -4,7 +4,10 @@ import (
 	"archive/zip"
 	"errors"
 	"fmt"
-	"path/filepath"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"strings"
 
 	"github.com/beevik/etree"
 	"golang.org/x/tools/godoc/vfs/zipfs"
@@ -12,10 +15,16 @@ import (
 
 // Epub represents a epub type book
 type Epub struct {
-	Title       string `json:"title"`
-	Author      string `json:"author"`
-	Language    string `json:"language"`
-	Description string `json:"description"`
+	Title       string   `json:"title"`
+	Author      string   `json:"author"`
+	Language    string   `json:"language"`
+	Description string   `json:"description"`
+	Identifiers []string `json:"identifiers"`
+	Series      string   `json:"series"`
+	SeriesIndex string   `json:"series_index"`
+	Version     string   `json:"version"`
+	HasCover    bool     `json:"has_cover"`
+	Valid       bool     `json:"valid"`
 }
 
 // ParseFile takes a filepath and returns an Epub if possible
@@ -29,60 +38,214 @@ func ParseFile(bookpath string) (bk *Epub, err error) {
 
 	book := new(Epub)
 	book.Language = ""
-	book.Title = filepath.Base(bookpath)
 
 	zr, err := zip.OpenReader(bookpath)
 	if err != nil {
 		return nil, err
 	}
+	defer zr.Close()
 
+	opf, rootfile, err := readPackage(zr)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range opf.FindElements("//title") {
+		book.Title = strings.TrimSpace(e.Text())
+		break
+	}
+	for _, e := range opf.FindElements("//creator") {
+		book.Author = strings.TrimSpace(e.Text())
+		break
+	}
+	for _, e := range opf.FindElements("//description") {
+		book.Description = e.Text()
+		break
+	}
+	for _, e := range opf.FindElements("//identifier") {
+		if id := strings.TrimSpace(e.Text()); id != "" {
+			book.Identifiers = append(book.Identifiers, id)
+		}
+	}
+	for _, e := range opf.FindElements("//language") {
+		book.Language = e.Text()
+		break
+	}
+	book.Series, book.SeriesIndex = series(opf)
+	if pkg := opf.SelectElement("package"); pkg != nil {
+		book.Version = pkg.SelectAttrValue("version", "")
+	}
+	book.HasCover = hasCover(opf)
+	book.Valid = isValid(opf, zr, rootfile)
+
+	return book, nil
+
+}
+
+// readPackage finds and parses the package document (opf) of an epub
+func readPackage(zr *zip.ReadCloser) (*etree.Document, string, error) {
 	zfs := zipfs.New(zr, "epub")
 
 	rsk, err := zfs.Open("/META-INF/container.xml")
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer rsk.Close()
 	container := etree.NewDocument()
 	_, err = container.ReadFrom(rsk)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	rootfile := ""
 	for _, e := range container.FindElements("//rootfiles/rootfile[@full-path]") {
 		rootfile = e.SelectAttrValue("full-path", "")
 	}
 	if rootfile == "" {
-		return nil, errors.New("Cannot parse container")
+		return nil, "", errors.New("Cannot parse container")
 	}
 
 	rootReadSeeker, err := zfs.Open("/" + rootfile)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer rootReadSeeker.Close()
 	opf := etree.NewDocument()
 	_, err = opf.ReadFrom(rootReadSeeker)
+	if err != nil {
+		return nil, "", err
+	}
+	return opf, rootfile, nil
+}
+
+// ErrNoCover is returned when an epub has no cover image
+var ErrNoCover = errors.New("Book has no cover")
+
+// Cover returns the cover image of an epub, the media type in the epub is
+// not returned because it can't be trusted
+func Cover(bookpath string) ([]byte, error) {
+	zr, err := zip.OpenReader(bookpath)
 	if err != nil {
 		return nil, err
 	}
-	book.Title = filepath.Base(bookpath)
-	for _, e := range opf.FindElements("//title") {
-		book.Title = e.Text()
-		break
+	defer zr.Close()
+
+	opf, rootfile, err := readPackage(zr)
+	if err != nil {
+		return nil, err
 	}
-	for _, e := range opf.FindElements("//creator") {
-		book.Author = e.Text()
-		break
+	item := coverItem(opf)
+	if item == nil {
+		return nil, ErrNoCover
 	}
-	for _, e := range opf.FindElements("//description") {
-		book.Description = e.Text()
-		break
+	href, err := url.PathUnescape(item.SelectAttrValue("href", ""))
+	if err != nil {
+		return nil, err
 	}
-	for _, e := range opf.FindElements("//language") {
-		book.Language = e.Text()
+
+	name := path.Join(path.Dir(rootfile), href)
+	for _, f := range zr.File {
+		if f.Name != name {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return data, nil
+	}
+	return nil, ErrNoCover
+}
+
+// coverItem returns the manifest item of the cover image, from an epub3
+// cover-image item or the epub2 cover meta
+func coverItem(opf *etree.Document) *etree.Element {
+	for _, e := range opf.FindElements("//manifest/item[@properties]") {
+		for _, p := range strings.Fields(e.SelectAttrValue("properties", "")) {
+			if p == "cover-image" {
+				return e
+			}
+		}
+	}
+	for _, m := range opf.FindElements("//meta[@name='cover']") {
+		id := m.SelectAttrValue("content", "")
+		for _, e := range opf.FindElements("//manifest/item[@id]") {
+			if e.SelectAttrValue("id", "") == id {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+// series returns the series and series index from calibre metadata or from
+// an epub3 collection
+func series(opf *etree.Document) (name, index string) {
+	for _, e := range opf.FindElements("//meta[@name='calibre:series']") {
+		name = e.SelectAttrValue("content", "")
+	}
+	for _, e := range opf.FindElements("//meta[@name='calibre:series_index']") {
+		index = e.SelectAttrValue("content", "")
+	}
+	if name != "" {
+		return name, index
+	}
+
+	for _, e := range opf.FindElements("//meta[@property='belongs-to-collection']") {
+		name = e.Text()
+		id := e.SelectAttrValue("id", "")
+		if id == "" {
+			break
+		}
+		for _, r := range opf.FindElements("//meta[@property='group-position']") {
+			if r.SelectAttrValue("refines", "") == "#"+id {
+				index = r.Text()
+			}
+		}
 		break
 	}
-	return book, nil
+	return strings.TrimSpace(name), strings.TrimSpace(index)
+}
+
+// hasCover checks for an epub2 cover meta or an epub3 cover-image item
+func hasCover(opf *etree.Document) bool {
+	if len(opf.FindElements("//meta[@name='cover']")) > 0 {
+		return true
+	}
+	for _, e := range opf.FindElements("//manifest/item[@properties]") {
+		for _, p := range strings.Fields(e.SelectAttrValue("properties", "")) {
+			if p == "cover-image" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isValid checks that the book has a spine and that every file in the
+// manifest is present in the archive
+func isValid(opf *etree.Document, zr *zip.ReadCloser, rootfile string) bool {
+	if len(opf.FindElements("//spine/itemref")) == 0 {
+		return false
+	}
 
+	files := make(map[string]bool)
+	for _, f := range zr.File {
+		files[f.Name] = true
+	}
+
+	base := path.Dir(rootfile)
+	for _, e := range opf.FindElements("//manifest/item[@href]") {
+		href, err := url.PathUnescape(e.SelectAttrValue("href", ""))
+		if err != nil {
+			return false
+		}
+		if !files[path.Join(base, href)] {
+			return false
+		}
+	}
+	return true
 }
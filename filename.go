@@ -0,0 +1,222 @@
+package booksing
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// MetadataSource describes where the value of a field of a book came from
+type MetadataSource string
+
+// all possible metadata sources
+const (
+	SourceOPF      MetadataSource = "opf"
+	SourceFilename MetadataSource = "filename"
+	SourceManual   MetadataSource = "manual"
+)
+
+// DefaultFilenamePatterns are the filename patterns that are tried in order
+const DefaultFilenamePatterns = "{author} - [{series} #{series_index}] {title};" +
+	"{author} - [{series} {series_index}] {title};" +
+	"{author} - {title};" +
+	"{title} - {author};" +
+	"{author}-{title}"
+
+var copyMarker = regexp.MustCompile(`\s*(\([0-9]+\)|copy)$`)
+
+// placeholders are values that are used when the real value is unknown
+var placeholders = map[string]bool{
+	"":               true,
+	"unknown":        true,
+	"unknown author": true,
+	"onbekend":       true,
+	"untitled":       true,
+	"no title":       true,
+}
+
+// FilenamePattern extracts metadata from a filename, a pattern like
+// "{author} - [{series} {series_index}] {title}" uses the fields author,
+// title, series and series_index, underscores in filenames match spaces
+type FilenamePattern struct {
+	pattern string
+	re      *regexp.Regexp
+}
+
+// FilenameMetadata holds the metadata found in a filename
+type FilenameMetadata struct {
+	Pattern     string
+	Author      string
+	Title       string
+	Series      string
+	SeriesIndex string
+}
+
+var patternFields = map[string]string{
+	"author":       `(?P<author>.+?)`,
+	"title":        `(?P<title>.+?)`,
+	"series":       `(?P<series>.+?)`,
+	"series_index": `(?P<series_index>[0-9]+(?:\.[0-9]+)?)`,
+}
+
+// ParseFilenamePatterns parses a list of patterns separated by semicolons
+func ParseFilenamePatterns(s string) ([]FilenamePattern, error) {
+	var patterns []FilenamePattern
+	for _, p := range strings.Split(s, ";") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		pattern, err := parseFilenamePattern(p)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, *pattern)
+	}
+	return patterns, nil
+}
+
+func parseFilenamePattern(p string) (*FilenamePattern, error) {
+	var expr strings.Builder
+	expr.WriteString(`^\s*`)
+	seen := make(map[string]bool)
+	rest := p
+	for rest != "" {
+		start := strings.Index(rest, "{")
+		if start < 0 {
+			expr.WriteString(patternLiteral(rest))
+			break
+		}
+		expr.WriteString(patternLiteral(rest[:start]))
+		end := strings.Index(rest[start:], "}")
+		if end < 0 {
+			return nil, fmt.Errorf("Unclosed field in filename pattern: %s", p)
+		}
+		field := rest[start+1 : start+end]
+		group, ok := patternFields[field]
+		if !ok {
+			return nil, fmt.Errorf("Unknown field in filename pattern: %s", field)
+		}
+		if seen[field] {
+			return nil, fmt.Errorf("Field %s is used twice in filename pattern: %s", field, p)
+		}
+		seen[field] = true
+		expr.WriteString(group)
+		rest = rest[start+end+1:]
+	}
+	expr.WriteString(`\s*$`)
+
+	if !seen["title"] {
+		return nil, fmt.Errorf("Filename pattern must contain the title: %s", p)
+	}
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, fmt.Errorf("Invalid filename pattern %s: %w", p, err)
+	}
+	return &FilenamePattern{
+		pattern: p,
+		re:      re,
+	}, nil
+}
+
+// patternLiteral matches s literally, any amount of whitespace matches a space
+func patternLiteral(s string) string {
+	var parts []string
+	for _, part := range strings.Split(s, " ") {
+		parts = append(parts, regexp.QuoteMeta(part))
+	}
+	return strings.Join(parts, `\s*`)
+}
+
+func (p FilenamePattern) String() string {
+	return p.pattern
+}
+
+// MatchFilename returns the metadata of every pattern that matches the name
+// of the file at bookpath, in the order of the patterns
+func MatchFilename(bookpath string, patterns []FilenamePattern) []FilenameMetadata {
+	name := strings.TrimSuffix(filepath.Base(bookpath), filepath.Ext(bookpath))
+	name = strings.Replace(name, "_", " ", -1)
+	name = copyMarker.ReplaceAllString(name, "")
+
+	var matches []FilenameMetadata
+	for _, p := range patterns {
+		m := p.re.FindStringSubmatch(name)
+		if m == nil {
+			continue
+		}
+		meta := FilenameMetadata{
+			Pattern: p.pattern,
+		}
+		for i, field := range p.re.SubexpNames() {
+			value := strings.TrimSpace(m[i])
+			switch field {
+			case "author":
+				meta.Author = value
+			case "title":
+				meta.Title = value
+			case "series":
+				meta.Series = value
+			case "series_index":
+				meta.SeriesIndex = value
+			}
+		}
+		matches = append(matches, meta)
+	}
+	return matches
+}
+
+// IsPlaceholder reports whether s is a value used when the real value is unknown
+func IsPlaceholder(s string) bool {
+	return placeholders[strings.ToLower(strings.TrimSpace(s))]
+}
+
+// ApplyFilename uses the filename of the book to fill in the title, author
+// and series when they are missing from the metadata. When both are present
+// the metadata wins, unless PreferFilename is set. The pattern that agrees
+// most with the metadata is used, so "Title - Author" and "Author - Title"
+// can be told apart.
+func (b *Book) ApplyFilename(bookpath string, opts ImportOptions) {
+	if b.Sources == nil {
+		b.Sources = make(map[string]MetadataSource)
+	}
+
+	matches := MatchFilename(bookpath, opts.FilenamePatterns)
+	if len(matches) == 0 {
+		if IsPlaceholder(b.Title) {
+			b.Title = FixTitle(strings.TrimSuffix(filepath.Base(bookpath), filepath.Ext(bookpath)), b.Language, opts.KeepCasing)
+			b.Sources["title"] = SourceFilename
+		}
+		return
+	}
+
+	best := matches[0]
+	bestScore := -1.0
+	for _, m := range matches {
+		score := 0.0
+		if !IsPlaceholder(b.Author) && m.Author != "" {
+			score += Similarity(b.Author, JoinAuthors(SplitAuthors(m.Author)))
+		}
+		if !IsPlaceholder(b.Title) {
+			score += Similarity(b.Title, FixTitle(m.Title, b.Language, opts.KeepCasing))
+		}
+		if score > bestScore {
+			best = m
+			bestScore = score
+		}
+	}
+
+	if best.Title != "" && (IsPlaceholder(b.Title) || opts.PreferFilename) {
+		b.Title = FixTitle(best.Title, b.Language, opts.KeepCasing)
+		b.Sources["title"] = SourceFilename
+	}
+	if best.Author != "" && (IsPlaceholder(b.Author) || opts.PreferFilename) {
+		b.SetAuthor(best.Author)
+		b.Sources["author"] = SourceFilename
+	}
+	if best.Series != "" && (b.Series == "" || opts.PreferFilename) {
+		b.Series, b.SeriesIndex = FixSeries(best.Series, best.SeriesIndex)
+		b.Sources["series"] = SourceFilename
+	}
+}
@@ -0,0 +1,95 @@
+package booksing
+
+import "testing"
+
+func Test_applyFilename(t *testing.T) {
+	patterns, err := ParseFilenamePatterns(DefaultFilenamePatterns)
+	if err != nil {
+		t.Fatalf("ParseFilenamePatterns() returned error: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		file           string
+		book           Book
+		preferFilename bool
+		want           Book
+		titleSource    MetadataSource
+	}{
+		{
+			name:        "missing title with series",
+			file:        "import/Macomber, Debbie - [Rose Harbor 2] Rose Harbor in bloei.epub",
+			book:        Book{Title: "Unknown", Author: "Unknown"},
+			want:        Book{Title: "Rose Harbor in bloei", Author: "Debbie Macomber", Series: "Rose Harbor", SeriesIndex: 2},
+			titleSource: SourceFilename,
+		},
+		{
+			name:        "series with hash sign",
+			file:        "Andre, Bella - [Sullivan #5] Als je van mij was.epub",
+			book:        Book{Title: "Unknown", Author: "Bella Andre"},
+			want:        Book{Title: "Als je van mij was", Author: "Bella Andre", Series: "Sullivan", SeriesIndex: 5},
+			titleSource: SourceFilename,
+		},
+		{
+			name:        "title before author agrees with metadata",
+			file:        "De patroonmeester - Octavia Estelle Butler.epub",
+			book:        Book{Title: "Unknown", Author: "Octavia Estelle Butler"},
+			want:        Book{Title: "De patroonmeester", Author: "Octavia Estelle Butler"},
+			titleSource: SourceFilename,
+		},
+		{
+			name:        "underscores",
+			file:        "A_C_Baantjer-16_De_Cock_En_Het_Dodelijk_Akkoord.epub",
+			book:        Book{Title: "Unknown", Author: "Unknown"},
+			want:        Book{Title: "16 De Cock En Het Dodelijk Akkoord", Author: "A.C. Baantjer"},
+			titleSource: SourceFilename,
+		},
+		{
+			name:        "metadata wins",
+			file:        "Goeken, Paul - Camouflage (1).epub",
+			book:        Book{Title: "Camouflage: Thriller", Author: "Paul Goeken"},
+			want:        Book{Title: "Camouflage: Thriller", Author: "Paul Goeken"},
+			titleSource: "",
+		},
+		{
+			name:           "filename wins",
+			file:           "Goeken, Paul - Camouflage (1).epub",
+			book:           Book{Title: "Camouflage: Thriller", Author: "Paul Goeken"},
+			preferFilename: true,
+			want:           Book{Title: "Camouflage", Author: "Paul Goeken"},
+			titleSource:    SourceFilename,
+		},
+		{
+			name:        "no pattern matches",
+			file:        "pg1228.epub",
+			book:        Book{Title: "Unknown", Author: "Unknown"},
+			want:        Book{Title: "Pg1228", Author: "Unknown"},
+			titleSource: SourceFilename,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := tt.book
+			b.ApplyFilename(tt.file, ImportOptions{
+				FilenamePatterns: patterns,
+				PreferFilename:   tt.preferFilename,
+				KeepCasing:       true,
+			})
+			if b.Title != tt.want.Title || b.Author != tt.want.Author || b.Series != tt.want.Series || b.SeriesIndex != tt.want.SeriesIndex {
+				t.Errorf("ApplyFilename() = %q, %q, %q, %v, want %q, %q, %q, %v", b.Title, b.Author, b.Series, b.SeriesIndex,
+					tt.want.Title, tt.want.Author, tt.want.Series, tt.want.SeriesIndex)
+			}
+			if b.Sources["title"] != tt.titleSource {
+				t.Errorf("title source = %v, want %v", b.Sources["title"], tt.titleSource)
+			}
+		})
+	}
+}
+
+func Test_parseFilenamePatternsErrors(t *testing.T) {
+	for _, s := range []string{"{author} - {title", "{author} - {name}", "{author}", "{title} - {title}"} {
+		if _, err := ParseFilenamePatterns(s); err == nil {
+			t.Errorf("ParseFilenamePatterns(%s) should fail", s)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package booksing
+
+import (
+	"time"
+)
+
+// FsckIssueType describes a kind of inconsistency found by the library checker
+type FsckIssueType string
+
+const (
+	// MissingFile is a book in the database whose file is gone from disk
+	MissingFile FsckIssueType = "missing-file"
+	// MisplacedFile is a book whose file was never moved out of the import dir
+	MisplacedFile FsckIssueType = "misplaced-file"
+	// OrphanFile is a file in the book dir that no book refers to
+	OrphanFile FsckIssueType = "orphan-file"
+	// NotIndexed is a book in the database that can't be found by search
+	NotIndexed FsckIssueType = "not-indexed"
+	// IndexOrphan is a search entry without a book in the database
+	IndexOrphan FsckIssueType = "index-orphan"
+	// StaleHash is a stored hash without a book in the database
+	StaleHash FsckIssueType = "stale-hash"
+	// MissingHash is a book in the database without a stored hash
+	MissingHash FsckIssueType = "missing-hash"
+	// ChecksumMismatch is a file whose content changed since it was imported
+	ChecksumMismatch FsckIssueType = "checksum-mismatch"
+	// MissingChecksum is a file that was imported before checksums were stored
+	MissingChecksum FsckIssueType = "missing-checksum"
+	// WrongCount means the stored total doesn't match the number of books
+	WrongCount FsckIssueType = "wrong-count"
+)
+
+// FsckIssue is a single inconsistency found by the library checker
+type FsckIssue struct {
+	Type   FsckIssueType
+	Hash   string
+	Path   string
+	Detail string
+	Fixed  bool
+}
+
+// FsckReport holds the result of a library consistency check, or of an
+// integrity check when Integrity is set
+type FsckReport struct {
+	ID        int `storm:"id,increment"`
+	StartTime time.Time
+	StopTime  time.Time
+	Fix       bool
+	Integrity bool
+	Books     int
+	Files     int
+	Issues    []FsckIssue
+}
+
+// Count returns the number of issues of the given type
+func (r *FsckReport) Count(t FsckIssueType) int {
+	count := 0
+	for _, issue := range r.Issues {
+		if issue.Type == t {
+			count++
+		}
+	}
+	return count
+}
+
+// Fixed returns the number of issues that have been fixed
+func (r *FsckReport) Fixed() int {
+	count := 0
+	for _, issue := range r.Issues {
+		if issue.Fixed {
+			count++
+		}
+	}
+	return count
+}
@@ -1,7 +1,9 @@
 package booksing
 
 import (
+	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
 	"unicode"
 
@@ -17,8 +19,128 @@ var betweenBockHooks = regexp.MustCompile(`\[.*\]`)
 var leadingZeroes = regexp.MustCompile(`^ *(0)([0-9]+) `)
 var alphaNumeric = regexp.MustCompile(`[^a-z0-9]+`)
 
+var numbering = regexp.MustCompile(`^ *0*([0-9]+) *[-.:)] +`)
+
 //var year = regexp.MustCompile(`(19[0-9]{2})|(20[0-9]{2})`)
 
+// articles holds the leading articles that are ignored per language
+var articles = map[string][]string{
+	"en": {"the", "a", "an"},
+	"nl": {"de", "het", "een", "'t"},
+	"de": {"der", "die", "das", "ein", "eine"},
+	"fr": {"le", "la", "les", "l'", "un", "une"},
+}
+
+// subtitles holds the generic subtitles that are ignored per language
+var subtitles = map[string][]string{
+	"en": {": a novel", " a novel"},
+	"nl": {": roman", ": een roman", " een roman"},
+	"de": {": roman", " ein roman"},
+	"fr": {": roman"},
+}
+
+// HashStrategy describes how the deduplication key of a book is computed,
+// changing it changes the hash of (almost) every book in the library
+type HashStrategy struct {
+	Version     int
+	SeriesIndex bool
+}
+
+// DefaultHashStrategy is the strategy libraries used before it was configurable
+var DefaultHashStrategy = HashStrategy{Version: 1}
+
+// ParseHashStrategy parses a strategy like "v2" or "v2+series"
+func ParseHashStrategy(s string) (HashStrategy, error) {
+	var strategy HashStrategy
+	parts := strings.Split(strings.ToLower(strings.TrimSpace(s)), "+")
+	switch parts[0] {
+	case "v1", "1":
+		strategy.Version = 1
+	case "v2", "2":
+		strategy.Version = 2
+	default:
+		return strategy, fmt.Errorf("Unknown hash version: %s", parts[0])
+	}
+	for _, option := range parts[1:] {
+		switch option {
+		case "series":
+			strategy.SeriesIndex = true
+		default:
+			return strategy, fmt.Errorf("Unknown hash option: %s", option)
+		}
+	}
+	return strategy, nil
+}
+
+func (s HashStrategy) String() string {
+	str := fmt.Sprintf("v%d", s.Version)
+	if s.SeriesIndex {
+		str += "+series"
+	}
+	return str
+}
+
+// Hash returns the deduplication key of b
+func (s HashStrategy) Hash(b *Book) string {
+	return s.hash(b.Author, b.Title, b.Language, b.Series, b.SeriesIndex)
+}
+
+// HashFile returns the deduplication key of a file attached to b, it is
+// used to find books whose files would no longer share a key
+func (s HashStrategy) HashFile(b *Book, f BookFile) string {
+	return s.hash(b.Author, f.Title, f.Language, b.Series, b.SeriesIndex)
+}
+
+func (s HashStrategy) hash(author, title, lang, series string, index float64) string {
+	var h string
+	if s.Version == 1 {
+		h = HashBook(author, title)
+	} else {
+		h = hashBookV2(author, title, lang)
+	}
+	if s.SeriesIndex && series != "" && index > 0 {
+		h += alphaNumeric.ReplaceAllString(strconv.FormatFloat(index, 'f', -1, 64), "")
+	}
+	return h
+}
+
+// hashBookV2 only strips leading numbers that are clearly numbering, and
+// ignores articles and generic subtitles in the language of the book
+func hashBookV2(author, title, lang string) string {
+	lang = strings.ToLower(strings.SplitN(lang, "-", 2)[0])
+	author = removeAccents(strings.ToLower(author))
+	author = strings.NewReplacer("-", " ", ".", " ").Replace(author)
+	title = removeAccents(strings.ToLower(title))
+
+	authorParts := strings.Fields(author)
+	lastName := ""
+	if len(authorParts) > 0 {
+		lastName = authorParts[len(authorParts)-1]
+		title = strings.Replace(title, strings.Join(authorParts, " "), "", -1)
+	}
+
+	title = betweenParentheses.ReplaceAllString(title, " ")
+	title = betweenBockHooks.ReplaceAllString(title, " ")
+	for _, subtitle := range subtitles[lang] {
+		title = strings.Replace(title, subtitle, " ", -1)
+	}
+	title = strings.TrimSpace(numbering.ReplaceAllString(title, ""))
+
+	for _, article := range articles[lang] {
+		if strings.HasSuffix(article, "'") && strings.HasPrefix(title, article) {
+			title = title[len(article):]
+			break
+		}
+		if strings.HasPrefix(title, article+" ") {
+			title = title[len(article)+1:]
+			break
+		}
+	}
+
+	return alphaNumeric.ReplaceAllString(lastName+" "+title, "")
+}
+
+// HashBook returns the deduplication key of version 1 of the hash strategy
 func HashBook(author, title string) string {
 	author = strings.ToLower(author)
 	author = strings.Replace(author, "-", " ", -1)
@@ -0,0 +1,89 @@
+package booksing
+
+import "testing"
+
+func Test_hashStrategy(t *testing.T) {
+	v2, _ := ParseHashStrategy("v2")
+	series, _ := ParseHashStrategy("v2+series")
+
+	tests := []struct {
+		name     string
+		strategy HashStrategy
+		a, b     Book
+		same     bool
+	}{
+		{
+			name:     "v1 strips leading numbers",
+			strategy: DefaultHashStrategy,
+			a:        Book{Author: "George Orwell", Title: "1984"},
+			b:        Book{Author: "George Orwell", Title: "Animal Farm"},
+			same:     false,
+		},
+		{
+			name:     "v2 keeps numeric titles",
+			strategy: v2,
+			a:        Book{Author: "George Orwell", Title: "1984"},
+			b:        Book{Author: "George Orwell", Title: "1984 (2001)"},
+			same:     true,
+		},
+		{
+			name:     "v2 strips numbering",
+			strategy: v2,
+			a:        Book{Author: "Appie Baantjer", Title: "02 - De Cock en de moord op Anna Bentveld", Language: "nl"},
+			b:        Book{Author: "Appie Baantjer", Title: "De Cock en de moord op Anna Bentveld", Language: "nl"},
+			same:     true,
+		},
+		{
+			name:     "v2 strips dutch articles",
+			strategy: v2,
+			a:        Book{Author: "Håkan Östlundh", Title: "De vrouw die wilde afrekenen", Language: "nl"},
+			b:        Book{Author: "Hakan Ostlundh", Title: "Vrouw die wilde afrekenen", Language: "nl"},
+			same:     true,
+		},
+		{
+			name:     "v2 keeps articles of other languages",
+			strategy: v2,
+			a:        Book{Author: "A Writer", Title: "Die Hard", Language: "en"},
+			b:        Book{Author: "A Writer", Title: "Hard", Language: "en"},
+			same:     false,
+		},
+		{
+			name:     "v2 strips german subtitles",
+			strategy: v2,
+			a:        Book{Author: "Daniel Kehlmann", Title: "Die Vermessung der Welt: Roman", Language: "de"},
+			b:        Book{Author: "Daniel Kehlmann", Title: "Vermessung der Welt", Language: "de"},
+			same:     true,
+		},
+		{
+			name:     "series index splits books",
+			strategy: series,
+			a:        Book{Author: "A Writer", Title: "Omnibus", Series: "Saga", SeriesIndex: 1},
+			b:        Book{Author: "A Writer", Title: "Omnibus", Series: "Saga", SeriesIndex: 2},
+			same:     false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := tt.strategy.Hash(&tt.a)
+			b := tt.strategy.Hash(&tt.b)
+			if (a == b) != tt.same {
+				t.Errorf("Hash() = %v and %v, want same = %v", a, b, tt.same)
+			}
+		})
+	}
+}
+
+func Test_parseHashStrategy(t *testing.T) {
+	for _, s := range []string{"v1", "v2", "v2+series"} {
+		strategy, err := ParseHashStrategy(s)
+		if err != nil {
+			t.Fatalf("ParseHashStrategy(%s) returned error: %v", s, err)
+		}
+		if strategy.String() != s {
+			t.Errorf("String() = %v, want %v", strategy.String(), s)
+		}
+	}
+	if _, err := ParseHashStrategy("v3"); err == nil {
+		t.Errorf("ParseHashStrategy(v3) should fail")
+	}
+}
@@ -0,0 +1,289 @@
+package booksing
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultPathTemplate puts books in first-letter/Author/Author-Title
+const DefaultPathTemplate = "{author_sort:1}/{author}/{author}-{title:30}"
+
+// PathFields are the fields that can be used in a path template
+var PathFields = []string{"author", "author_sort", "title", "series", "series_index", "language", "format"}
+
+// PathTemplate renders the location of a book in the library, in the style
+// of a Calibre save template. Fields are written as {field}, {field:N} keeps
+// the first N characters (or zero pads the series index to N digits) and
+// {field:|prefix|suffix} only adds prefix and suffix when the field is set.
+type PathTemplate struct {
+	src   string
+	parts []templatePart
+}
+
+type templatePart struct {
+	literal string
+	field   string
+	length  int
+	prefix  string
+	suffix  string
+}
+
+// Library describes where and how the files of books are stored, names are
+// transliterated to ASCII unless Unicode is set
+type Library struct {
+	Dir      string
+	Template *PathTemplate
+	Unicode  bool
+}
+
+// ReorganizeResult holds the result of moving all files to match a template
+type ReorganizeResult struct {
+	Template  string
+	StartTime time.Time
+	StopTime  time.Time
+	Books     int
+	Moved     int
+	Failed    int
+}
+
+// ParsePathTemplate parses and validates a path template
+func ParsePathTemplate(s string) (*PathTemplate, error) {
+	t := PathTemplate{src: s}
+	rest := s
+	for rest != "" {
+		start := strings.Index(rest, "{")
+		if start < 0 {
+			t.parts = append(t.parts, templatePart{literal: rest})
+			break
+		}
+		if start > 0 {
+			t.parts = append(t.parts, templatePart{literal: rest[:start]})
+		}
+		end := strings.Index(rest[start:], "}")
+		if end < 0 {
+			return nil, fmt.Errorf("Unclosed field in template: %s", rest[start:])
+		}
+		part, err := parseTemplateField(rest[start+1 : start+end])
+		if err != nil {
+			return nil, err
+		}
+		t.parts = append(t.parts, part)
+		rest = rest[start+end+1:]
+	}
+
+	if strings.HasPrefix(s, "/") || strings.Contains(s, "..") {
+		return nil, errors.New("Template must be relative to the book dir")
+	}
+	if strings.ContainsAny(strings.Join(t.literals(), ""), "{}") {
+		return nil, errors.New("Template contains an unbalanced brace")
+	}
+	if !t.has("title") {
+		return nil, errors.New("Template must contain the title")
+	}
+	if !t.has("author") && !t.has("author_sort") {
+		return nil, errors.New("Template must contain the author or author_sort")
+	}
+	return &t, nil
+}
+
+func parseTemplateField(s string) (templatePart, error) {
+	var part templatePart
+	field := s
+	spec := ""
+	if i := strings.Index(s, ":"); i >= 0 {
+		field, spec = s[:i], s[i+1:]
+	}
+	part.field = strings.TrimSpace(field)
+
+	known := false
+	for _, f := range PathFields {
+		if f == part.field {
+			known = true
+		}
+	}
+	if !known {
+		return part, fmt.Errorf("Unknown field in template: %s", part.field)
+	}
+
+	if strings.HasPrefix(spec, "|") {
+		affixes := strings.Split(spec[1:], "|")
+		if len(affixes) != 2 {
+			return part, fmt.Errorf("Field %s needs both a prefix and a suffix", part.field)
+		}
+		part.prefix, part.suffix = affixes[0], affixes[1]
+	} else if spec != "" {
+		n, err := strconv.Atoi(spec)
+		if err != nil || n <= 0 {
+			return part, fmt.Errorf("Invalid length for field %s: %s", part.field, spec)
+		}
+		part.length = n
+	}
+	return part, nil
+}
+
+func (t *PathTemplate) literals() []string {
+	var literals []string
+	for _, p := range t.parts {
+		if p.field == "" {
+			literals = append(literals, p.literal)
+		}
+	}
+	return literals
+}
+
+func (t *PathTemplate) has(field string) bool {
+	for _, p := range t.parts {
+		if p.field == field {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *PathTemplate) String() string {
+	return t.src
+}
+
+// Render returns the path of a file of b with the given format, relative to
+// the book dir and without an extension
+func (t *PathTemplate) Render(b *Book, format string) string {
+	return t.render(b, format, false)
+}
+
+func (t *PathTemplate) render(b *Book, format string, unicode bool) string {
+	var sb strings.Builder
+	for _, p := range t.parts {
+		if p.field == "" {
+			sb.WriteString(p.literal)
+			continue
+		}
+		value := templateValue(b, p, format, unicode)
+		if value == "" {
+			continue
+		}
+		sb.WriteString(p.prefix)
+		sb.WriteString(value)
+		sb.WriteString(p.suffix)
+	}
+
+	var segments []string
+	for _, segment := range strings.Split(sb.String(), "/") {
+		segment = strings.Join(strings.Fields(segment), "_")
+		segment = strings.Replace(segment, "__", "_", -1)
+		segment = strings.Trim(segment, ".")
+		if len(segment) > maxNameLength {
+			segment = truncateBytes(segment, maxNameLength)
+		}
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	return path.Join(segments...)
+}
+
+func templateValue(b *Book, p templatePart, format string, unicode bool) string {
+	safeName := func(s string) string {
+		return SafeName(s, unicode)
+	}
+	var value string
+	switch p.field {
+	case "author":
+		value = orUnknown(safeName(b.Author))
+	case "author_sort":
+		value = orUnknown(safeName(AuthorSort(b.Author)))
+	case "title":
+		value = orUnknown(safeName(b.Title))
+	case "series":
+		value = safeName(b.Series)
+	case "series_index":
+		if b.Series == "" || b.SeriesIndex <= 0 {
+			return ""
+		}
+		value = strconv.FormatFloat(b.SeriesIndex, 'f', -1, 64)
+		whole := strings.SplitN(value, ".", 2)[0]
+		if len(whole) < p.length {
+			value = strings.Repeat("0", p.length-len(whole)) + value
+		}
+		return value
+	case "language":
+		value = safeName(b.Language)
+	case "format":
+		value = safeName(format)
+	}
+	if p.length > 0 {
+		runes := []rune(value)
+		if len(runes) > p.length {
+			value = strings.TrimSpace(string(runes[:p.length]))
+		}
+	}
+	return value
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
+
+// Path returns the location in the library for file number i of b, the
+// primary file is number 0
+func (l Library) Path(b *Book, i int, f BookFile) string {
+	t := l.Template
+	if t == nil {
+		t, _ = ParsePathTemplate(DefaultPathTemplate)
+	}
+	p := filepath.Join(l.Dir, t.render(b, f.Format, l.Unicode))
+	if i > 0 {
+		p = fmt.Sprintf("%s-%d", p, i+1)
+	}
+	return p + filepath.Ext(f.Path)
+}
+
+// Layout describes how files are named in the library, it changes when
+// files need to be moved
+func (l Library) Layout() string {
+	if l.Unicode {
+		return "unicode:" + l.Template.String()
+	}
+	return l.Template.String()
+}
+
+// MoveFile moves the file at from to p, or to p with a number added to it
+// when a different file already exists at p, and returns where the file
+// went. The name is claimed before the file is moved, so files that are
+// moved to the same path at the same time can't overwrite each other.
+func MoveFile(from, p string) (string, error) {
+	err := os.MkdirAll(filepath.Dir(p), 0755)
+	if err != nil {
+		return "", err
+	}
+	ext := filepath.Ext(p)
+	base := strings.TrimSuffix(p, ext)
+	for i := 2; ; i++ {
+		if p == from {
+			return p, nil
+		}
+		f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			p = fmt.Sprintf("%s_%d%s", base, i, ext)
+			continue
+		} else if err != nil {
+			return "", err
+		}
+		f.Close()
+
+		err = os.Rename(from, p)
+		if err != nil {
+			_ = os.Remove(p)
+			return "", err
+		}
+		return p, nil
+	}
+}
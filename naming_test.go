@@ -0,0 +1,130 @@
+package booksing
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func Test_pathTemplate(t *testing.T) {
+	book := Book{
+		Author:      "Bella Andre",
+		Title:       "Als je van mij was",
+		Language:    "nl",
+		Series:      "Sullivan",
+		SeriesIndex: 5,
+	}
+	tests := []struct {
+		template string
+		book     Book
+		want     string
+	}{
+		{DefaultPathTemplate, book, "A/Bella_Andre/Bella_Andre-Als_je_van_mij_was"},
+		{"{author_sort}/{series:|[|] }{series_index:2} {title}", book, "Andre_Bella/[Sullivan]_05_Als_je_van_mij_was"},
+		{"{author_sort}/{series:|[|] }{title}", Book{Author: "Bella Andre", Title: "Losse titel"}, "Andre_Bella/Losse_titel"},
+		{"{language}/{format}/{author} - {title}", book, "nl/epub/Bella_Andre_-_Als_je_van_mij_was"},
+		{DefaultPathTemplate, Book{}, "u/unknown/unknown-unknown"},
+		{DefaultPathTemplate, Book{Author: "村上 春樹", Title: "..."}, "u/unknown/unknown-unknown"},
+		{DefaultPathTemplate, Book{Author: "Samuel Bjørk", Title: "Uilen jagen 's nachts"}, "B/Samuel_Bjork/Samuel_Bjork-Uilen_jagen_s_nachts"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.template+tt.want, func(t *testing.T) {
+			tpl, err := ParsePathTemplate(tt.template)
+			if err != nil {
+				t.Fatalf("ParsePathTemplate() returned error: %v", err)
+			}
+			if got := tpl.Render(&tt.book, "epub"); got != tt.want {
+				t.Errorf("Render() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parsePathTemplateErrors(t *testing.T) {
+	for _, s := range []string{
+		"{author}",
+		"{title}",
+		"{author}/{title",
+		"{author}/{publisher}/{title}",
+		"{author}/{title:abc}",
+		"/books/{author}/{title}",
+		"../{author}/{title}",
+	} {
+		if _, err := ParsePathTemplate(s); err == nil {
+			t.Errorf("ParsePathTemplate(%s) should fail", s)
+		}
+	}
+}
+
+func Test_safeName(t *testing.T) {
+	tests := []struct {
+		in      string
+		unicode bool
+		want    string
+	}{
+		{"Bjørk, Samuel", false, "Bjork Samuel"},
+		{"Stanisław Lem", false, "Stanislaw Lem"},
+		{"Фёдор Достоевский", false, "Fyodor Dostoevskiy"},
+		{"Νίκος Καζαντζάκης", false, "Nikos Kazantzakis"},
+		{"Straße: ein Roman?", false, "Strasse ein Roman"},
+		{"Фёдор Достоевский", true, "Фёдор Достоевский"},
+		{"Bjørk, Samuel / \"Det henger en engel\"", true, "Bjørk Samuel  Det henger en engel"},
+		{"東京", false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := SafeName(tt.in, tt.unicode); got != tt.want {
+				t.Errorf("SafeName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_moveFile(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "lib", "book.epub")
+
+	var wg sync.WaitGroup
+	moved := make([]string, 5)
+	for i := range moved {
+		from := filepath.Join(dir, fmt.Sprintf("import%d.epub", i))
+		err := ioutil.WriteFile(from, []byte{byte(i)}, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			p, err := MoveFile(from, target)
+			if err != nil {
+				t.Error(err)
+			}
+			moved[i] = p
+		}(i)
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool)
+	for i, p := range moved {
+		content, err := ioutil.ReadFile(p)
+		if err != nil || len(content) != 1 || content[0] != byte(i) {
+			t.Errorf("file %d was overwritten at %s", i, p)
+		}
+		seen[p] = true
+	}
+	if len(seen) != len(moved) {
+		sorted := append([]string{}, moved...)
+		sort.Strings(sorted)
+		t.Errorf("MoveFile() used the same path twice: %v", sorted)
+	}
+
+	// a file that already has a numbered name stays where it is
+	numbered := filepath.Join(dir, "lib", "book_2.epub")
+	p, err := MoveFile(numbered, target)
+	if err != nil || p != numbered {
+		t.Errorf("MoveFile(%s) = %s, %v, want it to stay", numbered, p, err)
+	}
+}
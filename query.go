@@ -0,0 +1,329 @@
+package booksing
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// QueryFields are the fields that can be used in a search query like
+// "author:macomber", added can also be used with a range like "added:>2020"
+var QueryFields = []string{"author", "title", "series", "lang", "format", "added", "shelf"}
+
+// SortOrders are the orders search results can be sorted in, relevance is
+// the default for searches and added for browsing without a search
+var SortOrders = []string{"relevance", "title", "author", "added", "series", "downloads"}
+
+// Query is a parsed search query, all terms must match
+type Query struct {
+	Terms []QueryTerm
+	Sort  string
+	// text holds each term as it was written, filters are added to and
+	// removed from it
+	text []string
+}
+
+// QueryTerm is a single part of a search query. Field is empty for free text,
+// terms on the added field hold the range of dates in Start and End, a zero
+// time means the range is open on that side. Terms on the shelf field hold
+// the icon in Value, the hashes of the books on that shelf are filled in
+// before searching since shelves differ per user.
+type QueryTerm struct {
+	Field  string
+	Value  string
+	Phrase bool
+	Negate bool
+	Start  time.Time
+	End    time.Time
+	Hashes []string
+}
+
+// QueryError describes invalid syntax in a search query
+type QueryError struct {
+	Pos int
+	Msg string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("Invalid search query at position %d: %s", e.Pos+1, e.Msg)
+}
+
+// IsEmpty reports whether the query has no terms at all
+func (q *Query) IsEmpty() bool {
+	return len(q.Terms) == 0
+}
+
+// ParseQuery parses a search query. Words and "quoted phrases" are searched
+// everywhere, field:value and field:"quoted value" only search a single
+// field and a leading - excludes the books that match. Dates are written as
+// 2020, 2020-05 or 2020-05-17, added also accepts >, >=, <, <= and from..to.
+func ParseQuery(s string) (*Query, error) {
+	q := &Query{}
+	pos := 0
+	for {
+		for pos < len(s) && isSpaceAt(s, pos) {
+			_, size := utf8.DecodeRuneInString(s[pos:])
+			pos += size
+		}
+		if pos >= len(s) {
+			return q, nil
+		}
+
+		start := pos
+		term := QueryTerm{}
+		if s[pos] == '-' {
+			pos++
+			if pos >= len(s) || isSpaceAt(s, pos) {
+				// a dash on its own, like in "author - title"
+				continue
+			}
+			term.Negate = true
+		}
+
+		if s[pos] != '"' {
+			end := pos
+			for end < len(s) {
+				r, size := utf8.DecodeRuneInString(s[end:])
+				if !unicode.IsLetter(r) {
+					break
+				}
+				end += size
+			}
+			if end > pos && end+1 < len(s) && s[end] == ':' && !isSpaceAt(s, end+1) {
+				field := strings.ToLower(s[pos:end])
+				if !isQueryField(field) {
+					return nil, &QueryError{pos, fmt.Sprintf("unknown field %s, use one of %s", field, strings.Join(QueryFields, ", "))}
+				}
+				term.Field = field
+				pos = end + 1
+			} else if end > pos && end+1 == len(s) && s[end] == ':' && isQueryField(strings.ToLower(s[pos:end])) {
+				return nil, &QueryError{start, fmt.Sprintf("%s: needs a value", strings.ToLower(s[pos:end]))}
+			}
+		}
+
+		value, phrase, next, err := readQueryValue(s, pos)
+		if err != nil {
+			return nil, err
+		}
+		if value == "" {
+			return nil, &QueryError{start, "empty phrase"}
+		}
+		term.Value = value
+		term.Phrase = phrase
+
+		switch term.Field {
+		case "added":
+			term.Start, term.End, err = parseDateRange(value)
+			if err != nil {
+				return nil, &QueryError{start, err.Error()}
+			}
+		case "shelf":
+			icon, err := ParseShelf(value)
+			if err != nil {
+				return nil, &QueryError{start, err.Error()}
+			}
+			term.Value = string(icon)
+		case "lang":
+			term.Value = FixLang(value)
+		case "format":
+			term.Value = strings.ToLower(strings.TrimPrefix(value, "."))
+		}
+
+		q.Terms = append(q.Terms, term)
+		q.text = append(q.text, s[start:next])
+		pos = next
+	}
+}
+
+// HasFilter reports whether the query only returns books that match filter,
+// a single field:value term like the ones QueryFilter returns
+func (q *Query) HasFilter(filter string) bool {
+	f, ok := filterTerm(filter)
+	if !ok {
+		return false
+	}
+	for _, t := range q.Terms {
+		if !t.Negate && sameTerm(t, f) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithFilter returns the text of the query with filter added, terms that
+// exclude the same value are left out
+func (q *Query) WithFilter(filter string) string {
+	return strings.TrimSpace(q.WithoutFilter(filter) + " " + filter)
+}
+
+// WithoutFilter returns the text of the query without the terms that search
+// or exclude the value of filter
+func (q *Query) WithoutFilter(filter string) string {
+	f, ok := filterTerm(filter)
+	var parts []string
+	for i, t := range q.Terms {
+		if i >= len(q.text) || (ok && sameTerm(t, f)) {
+			continue
+		}
+		parts = append(parts, q.text[i])
+	}
+	return strings.Join(parts, " ")
+}
+
+func filterTerm(filter string) (QueryTerm, bool) {
+	q, err := ParseQuery(filter)
+	if err != nil || len(q.Terms) != 1 {
+		return QueryTerm{}, false
+	}
+	return q.Terms[0], true
+}
+
+func sameTerm(a, b QueryTerm) bool {
+	return a.Field == b.Field && strings.EqualFold(a.Value, b.Value)
+}
+
+// QueryFilter returns the query term that searches field for value, the
+// value is quoted when needed
+func QueryFilter(field, value string) string {
+	if strings.ContainsAny(value, " \t:-") {
+		value = `"` + strings.Replace(value, `"`, "", -1) + `"`
+	}
+	return field + ":" + value
+}
+
+// SetSort sets the order of the results, an empty order picks the default
+func (q *Query) SetSort(s string) error {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" && q.IsEmpty() {
+		s = "added"
+	} else if s == "" {
+		s = "relevance"
+	}
+	for _, o := range SortOrders {
+		if o == s {
+			q.Sort = s
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown sort order %s, use one of %s", s, strings.Join(SortOrders, ", "))
+}
+
+func isQueryField(f string) bool {
+	for _, field := range QueryFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// readQueryValue reads a word or a quoted phrase starting at pos and returns
+// it with the position after it
+func readQueryValue(s string, pos int) (string, bool, int, error) {
+	if pos < len(s) && s[pos] == '"' {
+		end := strings.Index(s[pos+1:], "\"")
+		if end < 0 {
+			return "", false, 0, &QueryError{pos, "missing closing quote"}
+		}
+		return strings.TrimSpace(s[pos+1 : pos+1+end]), true, pos + end + 2, nil
+	}
+	end := pos
+	for end < len(s) && !isSpaceAt(s, end) {
+		if s[end] == '"' {
+			return "", false, 0, &QueryError{end, "quotes must surround the whole value"}
+		}
+		_, size := utf8.DecodeRuneInString(s[end:])
+		end += size
+	}
+	return s[pos:end], false, end, nil
+}
+
+// isSpaceAt reports whether the character that starts at byte i of s is a
+// space
+func isSpaceAt(s string, i int) bool {
+	r, _ := utf8.DecodeRuneInString(s[i:])
+	return unicode.IsSpace(r)
+}
+
+// parseDateRange returns the range of dates described by s, the start is
+// inclusive and the end exclusive
+func parseDateRange(s string) (time.Time, time.Time, error) {
+	var none time.Time
+	if parts := strings.SplitN(s, "..", 2); len(parts) == 2 {
+		var start, end time.Time
+		var err error
+		if parts[0] != "" {
+			start, _, err = parseDate(parts[0])
+			if err != nil {
+				return none, none, err
+			}
+		}
+		if parts[1] != "" {
+			_, end, err = parseDate(parts[1])
+			if err != nil {
+				return none, none, err
+			}
+		}
+		if !start.IsZero() && !end.IsZero() && !start.Before(end) {
+			return none, none, fmt.Errorf("%s ends before it starts", s)
+		}
+		return start, end, nil
+	}
+
+	for _, op := range []string{">=", "<=", ">", "<"} {
+		if !strings.HasPrefix(s, op) {
+			continue
+		}
+		start, end, err := parseDate(strings.TrimPrefix(s, op))
+		if err != nil {
+			return none, none, err
+		}
+		switch op {
+		case ">=":
+			return start, none, nil
+		case ">":
+			return end, none, nil
+		case "<=":
+			return none, end, nil
+		default:
+			return none, start, nil
+		}
+	}
+	return parseDate(s)
+}
+
+// parseDate returns the start and end of the year, month or day in s
+func parseDate(s string) (time.Time, time.Time, error) {
+	for _, layout := range []struct {
+		format string
+		years  int
+		months int
+		days   int
+	}{
+		{"2006", 1, 0, 0},
+		{"2006-01", 0, 1, 0},
+		{"2006-01-02", 0, 0, 1},
+	} {
+		t, err := time.ParseInLocation(layout.format, s, time.Local)
+		if err == nil {
+			return t, t.AddDate(layout.years, layout.months, layout.days), nil
+		}
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("%q is not a date like 2020, 2020-05 or 2020-05-17", s)
+}
+
+// Since returns the query limited to books imported after t
+func (q *Query) Since(t time.Time) *Query {
+	since := &Query{
+		Sort:  q.Sort,
+		Terms: append([]QueryTerm{}, q.Terms...),
+	}
+	since.Terms = append(since.Terms, QueryTerm{
+		Field: "imported",
+		Value: ">=" + t.Format(time.RFC3339),
+		Start: t,
+	})
+	return since
+}
@@ -0,0 +1,164 @@
+package booksing
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_parseQuery(t *testing.T) {
+	date := func(y, m, d int) time.Time {
+		return time.Date(y, time.Month(m), d, 0, 0, 0, 0, time.Local)
+	}
+	tests := []struct {
+		in   string
+		want []QueryTerm
+	}{
+		{"", nil},
+		{"dodelijk akkoord", []QueryTerm{{Value: "dodelijk"}, {Value: "akkoord"}}},
+		{`"dodelijk akkoord"`, []QueryTerm{{Value: "dodelijk akkoord", Phrase: true}}},
+		{"author:macomber lang:Dutch", []QueryTerm{{Field: "author", Value: "macomber"}, {Field: "lang", Value: "nl"}}},
+		{`title:"rose harbor" -format:.PDF`, []QueryTerm{{Field: "title", Value: "rose harbor", Phrase: true}, {Field: "format", Value: "pdf", Negate: true}}},
+		{"added:2020", []QueryTerm{{Field: "added", Value: "2020", Start: date(2020, 1, 1), End: date(2021, 1, 1)}}},
+		{"added:>2020", []QueryTerm{{Field: "added", Value: ">2020", Start: date(2021, 1, 1)}}},
+		{"added:<=2020-05", []QueryTerm{{Field: "added", Value: "<=2020-05", End: date(2020, 6, 1)}}},
+		{"added:2019-12-31..2020", []QueryTerm{{Field: "added", Value: "2019-12-31..2020", Start: date(2019, 12, 31), End: date(2021, 1, 1)}}},
+		{"-shelf:read", []QueryTerm{{Field: "shelf", Value: "checkmark-circle-outline", Negate: true}}},
+		{"Star Wars: Episode", []QueryTerm{{Value: "Star"}, {Value: "Wars:"}, {Value: "Episode"}}},
+		{"Goeken - Camouflage", []QueryTerm{{Value: "Goeken"}, {Value: "Camouflage"}}},
+		{"voilà Ångström", []QueryTerm{{Value: "voilà"}, {Value: "Ångström"}}},
+		{"author:Škvorecký\u00a0-title:Überfall", []QueryTerm{{Field: "author", Value: "Škvorecký"}, {Field: "title", Value: "Überfall", Negate: true}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseQuery(tt.in)
+			if err != nil {
+				t.Fatalf("ParseQuery() error = %v", err)
+			}
+			if !reflect.DeepEqual(got.Terms, tt.want) {
+				t.Errorf("ParseQuery() = %+v, want %+v", got.Terms, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseQueryErrors(t *testing.T) {
+	tests := []struct {
+		in  string
+		pos int
+	}{
+		{`title:"rose harbor`, 6},
+		{"autor:macomber", 0},
+		{"dodelijk added:yesterday", 9},
+		{"added:2021..2020", 0},
+		{"shelf:later", 0},
+		{`""`, 0},
+		{"lang:", 0},
+		{`rose"harbor`, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			_, err := ParseQuery(tt.in)
+			qerr, ok := err.(*QueryError)
+			if !ok {
+				t.Fatalf("ParseQuery() error = %v, want a QueryError", err)
+			}
+			if qerr.Pos != tt.pos {
+				t.Errorf("ParseQuery() error at %d, want %d: %v", qerr.Pos, tt.pos, qerr)
+			}
+		})
+	}
+}
+
+func Test_queryFilter(t *testing.T) {
+	tests := []struct {
+		field, value string
+		want         string
+	}{
+		{"lang", "nl", "lang:nl"},
+		{"author", "Debbie Macomber", `author:"Debbie Macomber"`},
+		{"series", `De "Cock"`, `series:"De Cock"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			got := QueryFilter(tt.field, tt.value)
+			if got != tt.want {
+				t.Errorf("QueryFilter() = %v, want %v", got, tt.want)
+			}
+			if _, err := ParseQuery(got); err != nil {
+				t.Errorf("ParseQuery(%s) error = %v", got, err)
+			}
+		})
+	}
+}
+
+func Test_queryFilters(t *testing.T) {
+	tests := []struct {
+		q, filter   string
+		has         bool
+		with, clear string
+	}{
+		{"macomber lang:nl", "lang:nl", true, "macomber lang:nl", "macomber"},
+		{"macomber -lang:nl", "lang:nl", false, "macomber lang:nl", "macomber"},
+		{"author:Ham", `author:Hamilton`, false, "author:Ham author:Hamilton", "author:Ham"},
+		{"author:hamilton", `author:Hamilton`, true, "author:Hamilton", ""},
+		{"added:2020..2021 -title:x", "added:2020", false, "added:2020..2021 -title:x added:2020", "added:2020..2021 -title:x"},
+		{`author:"Debbie Macomber"  lang:en`, `author:"Debbie Macomber"`, true, `lang:en author:"Debbie Macomber"`, "lang:en"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.q+"/"+tt.filter, func(t *testing.T) {
+			q, err := ParseQuery(tt.q)
+			if err != nil {
+				t.Fatalf("ParseQuery() error = %v", err)
+			}
+			if got := q.HasFilter(tt.filter); got != tt.has {
+				t.Errorf("HasFilter() = %v, want %v", got, tt.has)
+			}
+			if got := q.WithFilter(tt.filter); got != tt.with {
+				t.Errorf("WithFilter() = %v, want %v", got, tt.with)
+			}
+			if got := q.WithoutFilter(tt.filter); got != tt.clear {
+				t.Errorf("WithoutFilter() = %v, want %v", got, tt.clear)
+			}
+		})
+	}
+}
+
+func Test_querySetSort(t *testing.T) {
+	tests := []struct {
+		q, sort string
+		want    string
+		wantErr bool
+	}{
+		{"", "", "added", false},
+		{"macomber", "", "relevance", false},
+		{"macomber", "Title", "title", false},
+		{"", "relevance", "relevance", false},
+		{"macomber", "random", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.q+"/"+tt.sort, func(t *testing.T) {
+			q, _ := ParseQuery(tt.q)
+			err := q.SetSort(tt.sort)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SetSort() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if q.Sort != tt.want {
+				t.Errorf("SetSort() = %v, want %v", q.Sort, tt.want)
+			}
+		})
+	}
+}
+
+func Test_querySince(t *testing.T) {
+	q, _ := ParseQuery("author:macomber")
+	since := time.Date(2020, 5, 17, 0, 0, 0, 0, time.UTC)
+
+	got := q.Since(since)
+	if len(got.Terms) != 2 || got.Terms[1].Field != "imported" || !got.Terms[1].Start.Equal(since) {
+		t.Errorf("Since() = %+v, want the query limited to books imported since %v", got.Terms, since)
+	}
+	if len(q.Terms) != 1 {
+		t.Errorf("Since() changed the original query to %+v", q.Terms)
+	}
+}
@@ -0,0 +1,52 @@
+package booksing
+
+import (
+	"fmt"
+	"time"
+)
+
+// SavedSearch is a search query a user follows, the user is notified when a
+// new book matches it
+type SavedSearch struct {
+	ID        int    `storm:"id,increment"`
+	User      string `storm:"index"`
+	Name      string
+	Query     string
+	Created   time.Time
+	LastVisit time.Time
+}
+
+// Notification tells a user that a new book matches one of their saved
+// searches
+type Notification struct {
+	ID      string `storm:"id"`
+	User    string `storm:"index"`
+	Search  int
+	Name    string
+	Book    string
+	Title   string
+	Author  string
+	Created time.Time
+	Read    bool
+}
+
+// NotificationID returns the id of the notification for a book and a saved
+// search, a book only gets one notification per search
+func NotificationID(search int, hash string) string {
+	return fmt.Sprintf("%d/%s", search, hash)
+}
+
+// NewNotification returns the notification for a book that matches a saved
+// search
+func NewNotification(s SavedSearch, b Book) Notification {
+	return Notification{
+		ID:      NotificationID(s.ID, b.Hash),
+		User:    s.User,
+		Search:  s.ID,
+		Name:    s.Name,
+		Book:    b.Hash,
+		Title:   b.Title,
+		Author:  b.Author,
+		Created: time.Now(),
+	}
+}
@@ -2,6 +2,8 @@ package booksing
 
 import (
 	"errors"
+	"fmt"
+	"strings"
 )
 
 var ErrInvalidShelveIcon = errors.New("Invalid shelve icon provided")
@@ -29,3 +31,48 @@ func NextShelveIcon(cur ShelveIcon) (ShelveIcon, error) {
 func DefaultShelveIcon() ShelveIcon {
 	return shelveIcons[0]
 }
+
+// shelfNames are the names of the shelves that can be used in a search query
+var shelfNames = map[string]ShelveIcon{
+	"want":    "star",
+	"reading": "book-open-outline",
+	"read":    "checkmark-circle-outline",
+	"stopped": "close-outline",
+}
+
+// ParseShelf returns the icon of a shelf by its name, like "read", or by the
+// name of its icon
+func ParseShelf(s string) (ShelveIcon, error) {
+	s = strings.ToLower(s)
+	if icon, ok := shelfNames[s]; ok {
+		return icon, nil
+	}
+	for _, icon := range shelveIcons {
+		if string(icon) == s {
+			return icon, nil
+		}
+	}
+	return "", fmt.Errorf("unknown shelf %s, use one of want, reading, read or stopped", s)
+}
+
+// ShelfNames returns the names of the shelves in the order the icons rotate
+func ShelfNames() []string {
+	var names []string
+	for _, icon := range shelveIcons {
+		if name := ShelfName(icon); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// ShelfName returns the name of the shelf of an icon, the default icon is not
+// a shelf
+func ShelfName(icon ShelveIcon) string {
+	for name, i := range shelfNames {
+		if i == icon {
+			return name
+		}
+	}
+	return ""
+}
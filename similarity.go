@@ -0,0 +1,168 @@
+package booksing
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SimilarGroup is a set of books that are likely the same work
+type SimilarGroup struct {
+	ID        string `storm:"id"`
+	Hashes    []string
+	Score     float64
+	Reasons   []string
+	Found     time.Time
+	Dismissed bool
+}
+
+// GroupID returns the id of a group with the given hashes, it doesn't depend
+// on the order of the hashes
+func GroupID(hashes []string) string {
+	sorted := make([]string, len(hashes))
+	copy(sorted, hashes)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
+
+// Normalize lowercases s, removes accents and everything that isn't a letter
+// or a digit so it can be compared to other strings
+func Normalize(s string) string {
+	s = removeAccents(strings.ToLower(s))
+	s = alphaNumeric.ReplaceAllString(s, " ")
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// Similarity returns how similar two strings are, between 0 and 1, based on
+// the edit distance of their normalized forms
+func Similarity(a, b string) float64 {
+	a = Normalize(a)
+	b = Normalize(b)
+	if a == b {
+		return 1
+	}
+	max := len([]rune(a))
+	if l := len([]rune(b)); l > max {
+		max = l
+	}
+	return 1 - float64(Levenshtein(a, b))/float64(max)
+}
+
+// Levenshtein returns the edit distance between a and b
+func Levenshtein(a, b string) int {
+	ra := []rune(a)
+	rb := []rune(b)
+
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+// CompareBooks returns how likely it is that a and b are the same work,
+// between 0 and 1, and the reasons for that score
+func CompareBooks(a, b *Book) (float64, []string) {
+	sums := make(map[string]bool)
+	for _, sum := range a.Checksums() {
+		sums[sum] = true
+	}
+	for _, sum := range b.Checksums() {
+		if sums[sum] {
+			return 1, []string{"same file content"}
+		}
+	}
+
+	isbns := make(map[string]bool)
+	for _, id := range a.Identifiers {
+		if isbn := ISBN(id); isbn != "" {
+			isbns[isbn] = true
+		}
+	}
+	for _, id := range b.Identifiers {
+		if isbn := ISBN(id); isbn != "" && isbns[isbn] {
+			return 1, []string{fmt.Sprintf("same ISBN %s", isbn)}
+		}
+	}
+
+	authorScore := Similarity(a.Author, b.Author)
+	titleScore := Similarity(a.Title, b.Title)
+	reasons := []string{
+		fmt.Sprintf("author %.0f%% similar", authorScore*100),
+		fmt.Sprintf("title %.0f%% similar", titleScore*100),
+	}
+	return 0.4*authorScore + 0.6*titleScore, reasons
+}
+
+// ISBN returns the ISBN-13 form of id if it is a valid ISBN-10 or ISBN-13,
+// otherwise an empty string is returned
+func ISBN(id string) string {
+	var digits []byte
+	for _, r := range strings.ToUpper(id) {
+		if r >= '0' && r <= '9' || (r == 'X' && len(digits) == 9) {
+			digits = append(digits, byte(r))
+		}
+	}
+
+	switch len(digits) {
+	case 10:
+		sum := 0
+		for i, d := range digits {
+			v := int(d - '0')
+			if d == 'X' {
+				v = 10
+			}
+			sum += (10 - i) * v
+		}
+		if sum%11 != 0 {
+			return ""
+		}
+		return isbn13("978" + string(digits[:9]))
+	case 13:
+		if string(digits[:3]) != "978" && string(digits[:3]) != "979" {
+			return ""
+		}
+		if isbn13(string(digits[:12])) != string(digits) {
+			return ""
+		}
+		return string(digits)
+	}
+	return ""
+}
+
+// isbn13 adds the check digit to the first 12 digits of an ISBN-13
+func isbn13(first12 string) string {
+	sum := 0
+	for i, d := range first12 {
+		v := int(d - '0')
+		if i%2 == 1 {
+			v *= 3
+		}
+		sum += v
+	}
+	return fmt.Sprintf("%s%d", first12, (10-sum%10)%10)
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
@@ -0,0 +1,68 @@
+package booksing
+
+import "testing"
+
+func Test_levenshtein(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"kitten", "sitting", 3},
+		{"håkan", "hakan", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+"-"+tt.b, func(t *testing.T) {
+			if got := Levenshtein(tt.a, tt.b); got != tt.want {
+				t.Errorf("Levenshtein() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_compareBooks(t *testing.T) {
+	tests := []struct {
+		name    string
+		a, b    Book
+		atLeast float64
+		below   float64
+	}{
+		{
+			name:    "mangled accents",
+			a:       Book{Author: "Håkan Östlundh", Title: "De vrouw die wilde afrekenen"},
+			b:       Book{Author: "Hkan stlundh", Title: "De Vrouw Die Wilde Afrekenen"},
+			atLeast: 0.9,
+			below:   1.01,
+		},
+		{
+			name:    "same isbn",
+			a:       Book{Author: "A", Title: "B", Identifiers: []string{"urn:isbn:978-0-306-40615-7"}},
+			b:       Book{Author: "C", Title: "D", Identifiers: []string{"0306406152"}},
+			atLeast: 1,
+			below:   1.01,
+		},
+		{
+			name:    "placeholder isbn",
+			a:       Book{Author: "A", Title: "B", Identifiers: []string{"isbn:1234567890"}},
+			b:       Book{Author: "C", Title: "D", Identifiers: []string{"isbn:1234567890"}},
+			atLeast: 0,
+			below:   0.5,
+		},
+		{
+			name:    "different books",
+			a:       Book{Author: "Bram Stoker", Title: "Dracula"},
+			b:       Book{Author: "Mark Twain", Title: "The Adventures of Tom Sawyer"},
+			atLeast: 0,
+			below:   0.5,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := CompareBooks(&tt.a, &tt.b)
+			if got < tt.atLeast || got >= tt.below {
+				t.Errorf("CompareBooks() = %v, want between %v and %v", got, tt.atLeast, tt.below)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package storm
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	"github.com/gnur/booksing"
+	log "github.com/sirupsen/logrus"
+)
+
+// rebuild tracks a search index that is being built next to the one in use.
+// Books that change while it is built are written to both indexes and are
+// skipped when the books from the database are added, so the new index
+// never gets an older version of a book.
+type rebuild struct {
+	sync.Mutex
+	index    bleve.Index
+	changed  map[string]bool
+	progress booksing.IndexProgress
+}
+
+// indexBook adds or updates a book in the search index
+func (db *stormDB) indexBook(b booksing.Book) error {
+	db.rebuild.Lock()
+	defer db.rebuild.Unlock()
+
+	doc := db.newSearchDoc(b)
+	if db.rebuild.index != nil {
+		db.rebuild.changed[b.Hash] = true
+		err := db.rebuild.index.Index(b.Hash, doc)
+		if err != nil {
+			return fmt.Errorf("Unable to add book to new search index: %w", err)
+		}
+	}
+	return db.in.Index(b.Hash, doc)
+}
+
+// unindexBook removes a book from the search index
+func (db *stormDB) unindexBook(hash string) error {
+	db.rebuild.Lock()
+	defer db.rebuild.Unlock()
+
+	if db.rebuild.index != nil {
+		db.rebuild.changed[hash] = true
+		err := db.rebuild.index.Delete(hash)
+		if err != nil {
+			return fmt.Errorf("Unable to delete book from new search index: %w", err)
+		}
+	}
+	return db.in.Delete(hash)
+}
+
+// indexBatch adds books that haven't changed since the rebuild started to
+// index, total is the number of books that is being added
+func (db *stormDB) indexBatch(index bleve.Index, books []booksing.Book, total int) error {
+	db.rebuild.Lock()
+	defer db.rebuild.Unlock()
+
+	batch := index.NewBatch()
+	for _, b := range books {
+		if db.rebuild.changed[b.Hash] {
+			continue
+		}
+		err := batch.Index(b.Hash, db.newSearchDoc(b))
+		if err != nil {
+			return err
+		}
+	}
+	err := index.Batch(batch)
+	if err != nil {
+		return err
+	}
+	db.rebuild.progress.Done += len(books)
+	db.rebuild.progress.Total = total
+	return nil
+}
+
+// RebuildIndex builds a new search index from the database and swaps it in
+// when it is done, searches use the old index until then
+func (db *stormDB) RebuildIndex() error {
+	m, err := indexMapping()
+	if err != nil {
+		return err
+	}
+	checksum, err := mappingChecksum(m)
+	if err != nil {
+		return err
+	}
+
+	db.rebuild.Lock()
+	if db.rebuild.progress.Running {
+		db.rebuild.Unlock()
+		return errors.New("The search index is already being rebuilt")
+	}
+	name := fmt.Sprintf("search-%d.bleve", time.Now().Unix())
+	path := filepath.Join(db.dir, name)
+	index, err := bleve.New(path, m)
+	if err != nil {
+		db.rebuild.Unlock()
+		return fmt.Errorf("Unable to create new search index: %w", err)
+	}
+	db.rebuild.index = index
+	db.rebuild.changed = make(map[string]bool)
+	db.rebuild.progress = booksing.IndexProgress{
+		Running: true,
+		Started: time.Now(),
+	}
+	db.rebuild.Unlock()
+
+	err = db.fillIndex(index)
+	if err == nil {
+		err = db.saveIndexSettings(name, checksum)
+	}
+
+	db.rebuild.Lock()
+	defer db.rebuild.Unlock()
+	db.rebuild.index = nil
+	db.rebuild.changed = nil
+	db.rebuild.progress.Running = false
+	db.rebuild.progress.Finished = time.Now()
+	if err != nil {
+		db.rebuild.progress.Error = err.Error()
+		index.Close()
+		os.RemoveAll(path)
+		return fmt.Errorf("Unable to rebuild search index: %w", err)
+	}
+
+	old, oldPath := db.current, db.currentPath
+	db.in.Swap([]bleve.Index{index}, []bleve.Index{old})
+	db.current, db.currentPath = index, path
+	log.WithFields(log.Fields{
+		"books": db.rebuild.progress.Done,
+		"path":  path,
+	}).Info("swapped in rebuilt search index")
+
+	err = old.Close()
+	if err != nil {
+		log.WithError(err).Warning("could not close old search index")
+	}
+	err = os.RemoveAll(oldPath)
+	if err != nil {
+		log.WithError(err).Warning("could not remove old search index")
+	}
+	return nil
+}
+
+// GetIndexProgress returns the progress of the last rebuild of the search
+// index
+func (db *stormDB) GetIndexProgress() booksing.IndexProgress {
+	db.rebuild.Lock()
+	defer db.rebuild.Unlock()
+	return db.rebuild.progress
+}
@@ -0,0 +1,106 @@
+package storm
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/analysis/analyzer/standard"
+	"github.com/blevesearch/bleve/search/query"
+	"github.com/gnur/booksing"
+)
+
+// relatedTerms is the number of words of the description of a book that are
+// used to find related books
+const relatedTerms = 25
+
+// GetRelatedBooks returns books that are like the book with hash: by the same
+// author, in the same series or with a title or description that shares
+// words, the books in exclude are left out
+func (db *stormDB) GetRelatedBooks(hash string, exclude []string, limit int) ([]booksing.Book, error) {
+	b, err := db.GetBook(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	var should []query.Query
+	doc := db.newSearchDoc(*b)
+	for _, a := range doc.AuthorNames {
+		author := bleve.NewTermQuery(a)
+		author.SetField("AuthorNames")
+		author.SetBoost(2)
+		should = append(should, author)
+	}
+	for _, s := range doc.SeriesName {
+		series := bleve.NewTermQuery(s)
+		series.SetField("SeriesName")
+		series.SetBoost(3)
+		should = append(should, series)
+	}
+	for field, text := range map[string]string{"Title": b.Title, "Description": b.Description} {
+		for _, term := range db.significantTerms(text, b.Language, relatedTerms) {
+			tq := bleve.NewTermQuery(term)
+			tq.SetField(field)
+			should = append(should, tq)
+		}
+	}
+	if len(should) == 0 {
+		return nil, nil
+	}
+
+	related := bleve.NewBooleanQuery()
+	related.AddShould(should...)
+	related.AddMustNot(bleve.NewDocIDQuery(append([]string{hash}, exclude...)))
+
+	searchRequest := bleve.NewSearchRequest(related)
+	searchRequest.Size = limit
+	res, err := db.in.Search(searchRequest)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to search: %w", err)
+	}
+
+	hashes := make([]string, 0, len(res.Hits))
+	for _, hit := range res.Hits {
+		hashes = append(hashes, hit.ID)
+	}
+	return db.GetBooksByHash(hashes)
+}
+
+// significantTerms analyzes text like the search index does for lang and
+// returns the terms that occur most, longer terms first when they occur
+// equally often. Rare terms weigh more in the search, so the most frequent
+// terms of the text find books about the same subject.
+func (db *stormDB) significantTerms(text, lang string, max int) []string {
+	name, ok := languageAnalyzers[lang]
+	if !ok {
+		name = standard.Name
+	}
+	analyzer := db.in.Mapping().AnalyzerNamed(name)
+	if analyzer == nil {
+		return nil
+	}
+
+	counts := make(map[string]int)
+	for _, token := range analyzer.Analyze([]byte(text)) {
+		if len(token.Term) > 2 {
+			counts[string(token.Term)]++
+		}
+	}
+	terms := make([]string, 0, len(counts))
+	for t := range counts {
+		terms = append(terms, t)
+	}
+	sort.Slice(terms, func(i, j int) bool {
+		if counts[terms[i]] != counts[terms[j]] {
+			return counts[terms[i]] > counts[terms[j]]
+		}
+		if len(terms[i]) != len(terms[j]) {
+			return len(terms[i]) > len(terms[j])
+		}
+		return terms[i] < terms[j]
+	})
+	if len(terms) > max {
+		terms = terms[:max]
+	}
+	return terms
+}
@@ -0,0 +1,268 @@
+package storm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/asdine/storm"
+	"github.com/gnur/booksing"
+	bolt "go.etcd.io/bbolt"
+)
+
+// RenameHashes points everything that refers to a book by its hash to the
+// new hash of the book, renamed maps old hashes to new ones. A new hash can
+// be the old hash of another book, so everything that is renamed is removed
+// before the renamed records are stored. Records of books that end up with
+// the same hash are combined.
+func (db *stormDB) RenameHashes(renamed map[string]string) error {
+	return db.db.Bolt.Update(func(tx *bolt.Tx) error {
+		node := db.db.WithTransaction(tx)
+		for _, rename := range []func(storm.Node, map[string]string) error{
+			renameDownloads,
+			renameDuplicates,
+			renameTrash,
+			renameNotifications,
+			renameSimilarGroups,
+		} {
+			err := rename(node, renamed)
+			if err != nil {
+				return err
+			}
+		}
+		return renameMerged(tx, node, renamed)
+	})
+}
+
+// renameDownloads adds up the download counts of books that get the same
+// hash and points the download history to the new hashes
+func renameDownloads(tx storm.Node, renamed map[string]string) error {
+	counts := make(map[string]int)
+	for old, h := range renamed {
+		var count int
+		err := tx.Get("downloadcounts", old, &count)
+		if err == storm.ErrNotFound {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("Unable to get download count: %w", err)
+		}
+		counts[h] += count
+		err = tx.Delete("downloadcounts", old)
+		if err != nil {
+			return fmt.Errorf("Unable to delete download count: %w", err)
+		}
+	}
+	for h, count := range counts {
+		var current int
+		if _, ok := renamed[h]; !ok && tx.Get("downloadcounts", h, &current) == nil {
+			count += current
+		}
+		err := tx.Set("downloadcounts", h, count)
+		if err != nil {
+			return fmt.Errorf("Unable to store download count: %w", err)
+		}
+	}
+
+	var dls []download
+	err := tx.All(&dls)
+	if err != nil {
+		return fmt.Errorf("Unable to get downloads: %w", err)
+	}
+	for i := range dls {
+		h, ok := renamed[dls[i].Book]
+		if !ok || h == dls[i].Book {
+			continue
+		}
+		dls[i].Book = h
+		err = tx.Save(&dls[i])
+		if err != nil {
+			return fmt.Errorf("Unable to store download: %w", err)
+		}
+	}
+	return nil
+}
+
+func renameDuplicates(tx storm.Node, renamed map[string]string) error {
+	var dups []booksing.Duplicate
+	err := tx.All(&dups)
+	if err != nil {
+		return fmt.Errorf("Unable to get duplicates: %w", err)
+	}
+	for i := range dups {
+		d := &dups[i]
+		h, ok := renamed[d.Hash]
+		if !ok || h == d.Hash {
+			continue
+		}
+		if d.Candidate.Hash == d.Hash {
+			d.Candidate.Hash = h
+		}
+		d.Hash = h
+		err = tx.Save(d)
+		if err != nil {
+			return fmt.Errorf("Unable to store duplicate: %w", err)
+		}
+	}
+	return nil
+}
+
+func renameTrash(tx storm.Node, renamed map[string]string) error {
+	var trashed []booksing.TrashedBook
+	err := tx.All(&trashed)
+	if err != nil {
+		return fmt.Errorf("Unable to get trashed books: %w", err)
+	}
+	for i := range trashed {
+		t := &trashed[i]
+		h, ok := renamed[t.Hash]
+		if !ok || h == t.Hash {
+			continue
+		}
+		//books trashed before they had an id are stored under their hash
+		setTrashID(t)
+		t.Hash = h
+		t.Book.Hash = h
+		err = tx.Save(t)
+		if err != nil {
+			return fmt.Errorf("Unable to store trashed book: %w", err)
+		}
+	}
+	return nil
+}
+
+// renameNotifications points notifications to the new hashes, a user keeps
+// one notification per book and search
+func renameNotifications(tx storm.Node, renamed map[string]string) error {
+	var all, moved []booksing.Notification
+	err := tx.All(&all)
+	if err != nil {
+		return fmt.Errorf("Unable to get notifications: %w", err)
+	}
+	for _, n := range all {
+		h, ok := renamed[n.Book]
+		if !ok || h == n.Book {
+			continue
+		}
+		err = tx.DeleteStruct(&booksing.Notification{ID: n.ID})
+		if err != nil {
+			return fmt.Errorf("Unable to delete notification: %w", err)
+		}
+		n.Book = h
+		n.ID = booksing.NotificationID(n.Search, h)
+		moved = append(moved, n)
+	}
+	for i := range moved {
+		var existing booksing.Notification
+		err = tx.One("ID", moved[i].ID, &existing)
+		if err == nil {
+			continue
+		} else if err != storm.ErrNotFound {
+			return fmt.Errorf("Unable to get notification: %w", err)
+		}
+		err = tx.Save(&moved[i])
+		if err != nil {
+			return fmt.Errorf("Unable to store notification: %w", err)
+		}
+	}
+	return nil
+}
+
+// renameSimilarGroups points groups to the new hashes, groups that are left
+// with a single book were merged and are removed
+func renameSimilarGroups(tx storm.Node, renamed map[string]string) error {
+	var all, moved []booksing.SimilarGroup
+	err := tx.All(&all)
+	if err != nil {
+		return fmt.Errorf("Unable to get similar groups: %w", err)
+	}
+	for _, g := range all {
+		var hashes []string
+		seen := make(map[string]bool)
+		changed := false
+		for _, old := range g.Hashes {
+			h, ok := renamed[old]
+			if !ok {
+				h = old
+			}
+			changed = changed || h != old
+			if !seen[h] {
+				seen[h] = true
+				hashes = append(hashes, h)
+			}
+		}
+		if !changed {
+			continue
+		}
+		err = tx.DeleteStruct(&booksing.SimilarGroup{ID: g.ID})
+		if err != nil {
+			return fmt.Errorf("Unable to delete similar group: %w", err)
+		}
+		if len(hashes) < 2 {
+			continue
+		}
+		g.Hashes = hashes
+		g.ID = booksing.GroupID(hashes)
+		moved = append(moved, g)
+	}
+	for i := range moved {
+		var existing booksing.SimilarGroup
+		err = tx.One("ID", moved[i].ID, &existing)
+		if err == nil {
+			continue
+		} else if err != storm.ErrNotFound {
+			return fmt.Errorf("Unable to get similar group: %w", err)
+		}
+		err = tx.Save(&moved[i])
+		if err != nil {
+			return fmt.Errorf("Unable to store similar group: %w", err)
+		}
+	}
+	return nil
+}
+
+// renameMerged points merged books to the new hash of the book they were
+// merged into. A merged hash that now belongs to a book is forgotten, new
+// imports of it are that book.
+func renameMerged(tx *bolt.Tx, node storm.Node, renamed map[string]string) error {
+	bucket := tx.Bucket([]byte("merged"))
+	if bucket == nil {
+		return nil
+	}
+	var merged []string
+	err := bucket.ForEach(func(k, v []byte) error {
+		if !strings.HasPrefix(string(k), "__storm") {
+			merged = append(merged, string(k))
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to get merged hashes: %w", err)
+	}
+
+	current := make(map[string]bool)
+	for _, h := range renamed {
+		current[h] = true
+	}
+	for _, hash := range merged {
+		if current[hash] {
+			err = node.Delete("merged", hash)
+			if err != nil {
+				return fmt.Errorf("Unable to delete merged hash: %w", err)
+			}
+			continue
+		}
+		var into string
+		err = node.Get("merged", hash, &into)
+		if err != nil {
+			return fmt.Errorf("Unable to get merged hash: %w", err)
+		}
+		h, ok := renamed[into]
+		if !ok || h == into {
+			continue
+		}
+		err = node.Set("merged", hash, h)
+		if err != nil {
+			return fmt.Errorf("Unable to store merged hash: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package storm
+
+import (
+	"fmt"
+
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+	"github.com/blevesearch/bleve"
+	"github.com/gnur/booksing"
+)
+
+func (db *stormDB) SaveSearch(s *booksing.SavedSearch) error {
+	return db.db.Save(s)
+}
+
+func (db *stormDB) GetSavedSearch(id int) (*booksing.SavedSearch, error) {
+	var s booksing.SavedSearch
+	err := db.db.One("ID", id, &s)
+	if err == storm.ErrNotFound {
+		return &s, booksing.ErrNotFound
+	}
+	return &s, err
+}
+
+// GetSavedSearches returns the saved searches of a user, or of all users
+// when user is empty
+func (db *stormDB) GetSavedSearches(user string) ([]booksing.SavedSearch, error) {
+	var searches []booksing.SavedSearch
+	var err error
+	if user == "" {
+		err = db.db.All(&searches)
+	} else {
+		err = db.db.Find("User", user, &searches)
+	}
+	if err != nil && err != storm.ErrNotFound {
+		return nil, fmt.Errorf("Unable to get saved searches from db: %w", err)
+	}
+	return searches, nil
+}
+
+func (db *stormDB) DeleteSavedSearch(id int) error {
+	return db.db.DeleteStruct(&booksing.SavedSearch{ID: id})
+}
+
+// CountBooks returns the number of books that match a query
+func (db *stormDB) CountBooks(q *booksing.Query) (int, error) {
+	searchRequest := bleve.NewSearchRequest(bleveQuery(q, 0))
+	searchRequest.Size = 0
+	res, err := db.in.Search(searchRequest)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to search: %w", err)
+	}
+	return int(res.Total), nil
+}
+
+// MatchBooks returns which of the books with the given hashes match a query
+func (db *stormDB) MatchBooks(q *booksing.Query, hashes []string) ([]string, error) {
+	if len(hashes) == 0 {
+		return nil, nil
+	}
+	searchRequest := bleve.NewSearchRequest(bleve.NewConjunctionQuery(
+		bleveQuery(q, 0),
+		bleve.NewDocIDQuery(hashes),
+	))
+	searchRequest.Size = len(hashes)
+	res, err := db.in.Search(searchRequest)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to search: %w", err)
+	}
+	var matches []string
+	for _, hit := range res.Hits {
+		matches = append(matches, hit.ID)
+	}
+	return matches, nil
+}
+
+// AddNotification stores a notification unless the user was already notified
+// of the same book for the same search, it reports whether it was added
+func (db *stormDB) AddNotification(n booksing.Notification) (bool, error) {
+	var existing booksing.Notification
+	err := db.db.One("ID", n.ID, &existing)
+	if err == nil {
+		return false, nil
+	} else if err != storm.ErrNotFound {
+		return false, fmt.Errorf("Unable to get notification from db: %w", err)
+	}
+	err = db.db.Save(&n)
+	if err != nil {
+		return false, fmt.Errorf("Unable to store notification: %w", err)
+	}
+	return true, nil
+}
+
+// GetNotifications returns the newest notifications of a user
+func (db *stormDB) GetNotifications(user string, limit int) ([]booksing.Notification, error) {
+	var notifications []booksing.Notification
+	err := db.db.Select(q.Eq("User", user)).OrderBy("Created").Reverse().Limit(limit).Find(&notifications)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, fmt.Errorf("Unable to get notifications from db: %w", err)
+	}
+	return notifications, nil
+}
+
+// CountUnreadNotifications returns the number of notifications a user hasn't
+// seen yet
+func (db *stormDB) CountUnreadNotifications(user string) (int, error) {
+	return db.db.Select(q.Eq("User", user), q.Eq("Read", false)).Count(&booksing.Notification{})
+}
+
+// MarkNotificationsRead marks all notifications of a user as read
+func (db *stormDB) MarkNotificationsRead(user string) error {
+	var notifications []booksing.Notification
+	err := db.db.Select(q.Eq("User", user), q.Eq("Read", false)).Find(&notifications)
+	if err == storm.ErrNotFound {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("Unable to get notifications from db: %w", err)
+	}
+	for _, n := range notifications {
+		err = db.db.UpdateField(&n, "Read", true)
+		if err != nil {
+			return fmt.Errorf("Unable to update notification: %w", err)
+		}
+	}
+	return nil
+}
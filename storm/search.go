@@ -0,0 +1,539 @@
+package storm
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/asdine/storm"
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/analysis/analyzer/custom"
+	"github.com/blevesearch/bleve/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/analysis/analyzer/standard"
+	"github.com/blevesearch/bleve/analysis/char/asciifolding"
+	"github.com/blevesearch/bleve/analysis/lang/de"
+	"github.com/blevesearch/bleve/analysis/lang/en"
+	"github.com/blevesearch/bleve/analysis/lang/es"
+	"github.com/blevesearch/bleve/analysis/lang/fr"
+	"github.com/blevesearch/bleve/analysis/lang/it"
+	"github.com/blevesearch/bleve/analysis/lang/nl"
+	"github.com/blevesearch/bleve/analysis/token/lowercase"
+	"github.com/blevesearch/bleve/analysis/tokenizer/unicode"
+	"github.com/blevesearch/bleve/mapping"
+	"github.com/blevesearch/bleve/search"
+	"github.com/blevesearch/bleve/search/highlight/format/html"
+	"github.com/blevesearch/bleve/search/query"
+	"github.com/gnur/booksing"
+	log "github.com/sirupsen/logrus"
+)
+
+// indexVersion is increased when the documents in the search index change,
+// the index is rebuilt when it was built by an older version or with another
+// mapping
+const (
+	indexVersion        = 5
+	indexVersionSetting = "indexversion"
+	indexMappingSetting = "indexmapping"
+	indexPathSetting    = "indexpath"
+	defaultIndexName    = "search.bleve"
+	indexBatchSize      = 500
+)
+
+// languageAnalyzers are the analyzers for the text of books by language,
+// books in other languages are analyzed with the standard analyzer
+var languageAnalyzers = map[string]string{
+	"en": en.AnalyzerName,
+	"nl": nl.AnalyzerName,
+	"de": de.AnalyzerName,
+	"fr": fr.AnalyzerName,
+	"es": es.AnalyzerName,
+	"it": it.AnalyzerName,
+}
+
+// nameAnalyzer is used for names of authors, they are not stemmed and
+// accents are ignored
+const nameAnalyzer = "name"
+
+// searchDoc is the document stored in the search index for a book, the
+// extra fields are indexed as a whole so they can be used as facets and to
+// sort on
+type searchDoc struct {
+	booksing.Book
+	Formats     []string
+	AuthorNames []string
+	SeriesName  []string
+	SeriesSort  []string
+	AddedYear   string
+	TitleSort   string
+	AuthorSort  string
+	Downloads   int
+}
+
+// BleveType picks the mapping of the language of the book
+func (d searchDoc) BleveType() string {
+	if _, ok := languageAnalyzers[d.Language]; ok {
+		return "book_" + d.Language
+	}
+	return "book"
+}
+
+func (db *stormDB) newSearchDoc(b booksing.Book) searchDoc {
+	doc := searchDoc{
+		Book:        b,
+		AuthorNames: b.Authors,
+		AddedYear:   b.Added.Format("2006"),
+		TitleSort:   strings.ToLower(b.Title),
+		AuthorSort:  strings.ToLower(booksing.AuthorSort(b.Author)),
+		Downloads:   db.GetDownloadCount(b.Hash),
+	}
+	if len(doc.AuthorNames) == 0 {
+		doc.AuthorNames = []string{b.Author}
+	}
+	if len(b.Authors) > 0 {
+		doc.AuthorSort = strings.ToLower(booksing.AuthorSort(b.Authors[0]))
+	}
+	if b.Series != "" {
+		doc.SeriesName = []string{b.Series}
+		doc.SeriesSort = []string{strings.ToLower(b.Series)}
+	}
+	for _, f := range b.AllFiles() {
+		doc.Formats = append(doc.Formats, f.Format)
+	}
+	return doc
+}
+
+// sortOrders maps the sort orders of a query to the fields in the index, the
+// document ID is added to every order so paging is stable
+var sortOrders = map[string]search.SortOrder{
+	"relevance": {
+		&search.SortScore{Desc: true},
+	},
+	"title": {
+		&search.SortField{Field: "TitleSort"},
+	},
+	"author": {
+		&search.SortField{Field: "AuthorSort"},
+		&search.SortField{Field: "TitleSort"},
+	},
+	"added": {
+		&search.SortField{Field: "Added", Type: search.SortFieldAsDate, Desc: true},
+	},
+	"series": {
+		&search.SortField{Field: "SeriesSort", Missing: search.SortFieldMissingLast},
+		&search.SortField{Field: "SeriesIndex", Type: search.SortFieldAsNumber},
+		&search.SortField{Field: "TitleSort"},
+	},
+	"downloads": {
+		&search.SortField{Field: "Downloads", Type: search.SortFieldAsNumber, Desc: true},
+		&search.SortField{Field: "Added", Type: search.SortFieldAsDate, Desc: true},
+	},
+}
+
+func sortOrder(name string) search.SortOrder {
+	order, ok := sortOrders[name]
+	if !ok {
+		order = sortOrders["relevance"]
+	}
+	return append(order.Copy(), &search.SortDocID{})
+}
+
+// facets are the facets returned with search results, by the query field
+// they filter on
+var facets = []struct {
+	field string
+	title string
+	index string
+}{
+	{"lang", "Language", "Language"},
+	{"author", "Author", "AuthorNames"},
+	{"series", "Series", "SeriesName"},
+	{"format", "Format", "Formats"},
+	{"added", "Added", "AddedYear"},
+}
+
+const facetSize = 10
+
+// highlightFields are the fields that show why a book matched, by the name
+// that is shown to the user
+var highlightFields = []struct {
+	name  string
+	index string
+}{
+	{"title", "Title"},
+	{"author", "Author"},
+	{"series", "Series"},
+	{"description", "Description"},
+}
+
+func addHighlight(req *bleve.SearchRequest) {
+	req.Highlight = bleve.NewHighlightWithStyle(html.Name)
+	for _, f := range highlightFields {
+		req.Highlight.AddField(f.index)
+	}
+}
+
+// searchHighlights returns the matched fragments of every hit by book hash
+func searchHighlights(res *bleve.SearchResult) map[string][]booksing.Highlight {
+	highlights := make(map[string][]booksing.Highlight)
+	for _, hit := range res.Hits {
+		for _, f := range highlightFields {
+			var fragments []string
+			for _, fragment := range hit.Fragments[f.index] {
+				// bleve returns the start of a field when nothing in it matched
+				if strings.Contains(fragment, "<mark>") {
+					fragments = append(fragments, fragment)
+				}
+			}
+			if len(fragments) == 0 {
+				continue
+			}
+			highlights[hit.ID] = append(highlights[hit.ID], booksing.Highlight{
+				Field:     f.name,
+				Fragments: fragments,
+			})
+		}
+	}
+	return highlights
+}
+
+func addFacets(req *bleve.SearchRequest) {
+	for _, f := range facets {
+		req.AddFacet(f.field, bleve.NewFacetRequest(f.index, facetSize))
+	}
+}
+
+// searchFacets returns the facets of a search result in a fixed order
+func searchFacets(res *bleve.SearchResult) []booksing.Facet {
+	var result []booksing.Facet
+	for _, f := range facets {
+		fr, ok := res.Facets[f.field]
+		if !ok {
+			continue
+		}
+		facet := booksing.Facet{
+			Field: f.field,
+			Title: f.title,
+		}
+		for _, t := range fr.Terms {
+			if t.Term == "" {
+				continue
+			}
+			facet.Values = append(facet.Values, booksing.FacetValue{
+				Value:  t.Term,
+				Count:  t.Count,
+				Filter: booksing.QueryFilter(f.field, t.Term),
+			})
+		}
+		if len(facet.Values) > 0 {
+			result = append(result, facet)
+		}
+	}
+	return result
+}
+
+// textFields are the fields that words are searched in, with the analyzer
+// they are indexed with and how much a match counts
+var textFields = []struct {
+	name   string
+	byLang bool
+	boost  float64
+}{
+	{"Title", true, 3},
+	{"Author", false, 2},
+	{"Authors", false, 2},
+	{"Series", true, 1.5},
+	{"Description", true, 1},
+}
+
+// bookMapping maps the fields of a book, text is analyzed with the analyzer
+// of the language, only fields that are searched, sorted on or shown as
+// facet are indexed
+func bookMapping(analyzer string) *mapping.DocumentMapping {
+	keywordField := bleve.NewTextFieldMapping()
+	keywordField.Analyzer = keyword.Name
+	keywordField.Store = false
+	keywordField.IncludeInAll = false
+
+	book := bleve.NewDocumentStaticMapping()
+	for _, f := range textFields {
+		text := bleve.NewTextFieldMapping()
+		text.Analyzer = nameAnalyzer
+		if f.byLang {
+			text.Analyzer = analyzer
+		}
+		text.IncludeTermVectors = true
+		book.AddFieldMappingsAt(f.name, text)
+	}
+	for _, f := range []string{"Hash", "Language", "Formats", "AuthorNames", "SeriesName", "AddedYear", "TitleSort", "AuthorSort", "SeriesSort"} {
+		book.AddFieldMappingsAt(f, keywordField)
+	}
+	book.AddFieldMappingsAt("Added", bleve.NewDateTimeFieldMapping())
+	book.AddFieldMappingsAt("Imported", bleve.NewDateTimeFieldMapping())
+	book.AddFieldMappingsAt("SeriesIndex", bleve.NewNumericFieldMapping())
+	book.AddFieldMappingsAt("Downloads", bleve.NewNumericFieldMapping())
+	return book
+}
+
+func indexMapping() (mapping.IndexMapping, error) {
+	m := bleve.NewIndexMapping()
+	err := m.AddCustomAnalyzer(nameAnalyzer, map[string]interface{}{
+		"type":          custom.Name,
+		"char_filters":  []string{asciifolding.Name},
+		"tokenizer":     unicode.Name,
+		"token_filters": []string{lowercase.Name},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to add name analyzer: %w", err)
+	}
+	m.DefaultMapping = bookMapping(standard.Name)
+	for lang, analyzer := range languageAnalyzers {
+		m.AddDocumentMapping("book_"+lang, bookMapping(analyzer))
+	}
+	return m, nil
+}
+
+// mappingChecksum identifies a mapping, so the index is rebuilt when the
+// mapping changes
+func mappingChecksum(m mapping.IndexMapping) (string, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return "", fmt.Errorf("Unable to encode index mapping: %w", err)
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(data)), nil
+}
+
+// openIndex opens the search index in dir, it is built from the books in the
+// database when it doesn't exist, was built by an older version or with
+// another mapping
+func (db *stormDB) openIndex(dir string) error {
+	db.dir = dir
+	name := defaultIndexName
+	err := db.GetSetting(indexPathSetting, &name)
+	if err != nil && err != booksing.ErrNotFound {
+		return fmt.Errorf("Unable to get search index path: %w", err)
+	}
+	path := filepath.Join(dir, name)
+
+	var version int
+	err = db.GetSetting(indexVersionSetting, &version)
+	if err != nil && err != booksing.ErrNotFound {
+		return fmt.Errorf("Unable to get search index version: %w", err)
+	}
+	var checksum string
+	err = db.GetSetting(indexMappingSetting, &checksum)
+	if err != nil && err != booksing.ErrNotFound {
+		return fmt.Errorf("Unable to get search index mapping: %w", err)
+	}
+	m, err := indexMapping()
+	if err != nil {
+		return err
+	}
+	newChecksum, err := mappingChecksum(m)
+	if err != nil {
+		return err
+	}
+
+	if version == indexVersion && checksum == newChecksum {
+		index, err := bleve.Open(path)
+		if err == nil {
+			db.current, db.currentPath = index, path
+			db.in = bleve.NewIndexAlias(index)
+			return nil
+		} else if err != bleve.ErrorIndexPathDoesNotExist {
+			return err
+		}
+	}
+
+	err = os.RemoveAll(path)
+	if err != nil {
+		return fmt.Errorf("Unable to remove old search index: %w", err)
+	}
+	index, err := bleve.New(path, m)
+	if err != nil {
+		return err
+	}
+	db.current, db.currentPath = index, path
+	db.in = bleve.NewIndexAlias(index)
+
+	err = db.fillIndex(index)
+	if err != nil {
+		return err
+	}
+	return db.saveIndexSettings(name, newChecksum)
+}
+
+// fillIndex adds all books in the database to a new search index
+func (db *stormDB) fillIndex(index bleve.Index) error {
+	err := db.countDownloads()
+	if err != nil {
+		return err
+	}
+	books, err := db.GetAllBooks()
+	if err != nil {
+		return fmt.Errorf("Unable to get books from db: %w", err)
+	}
+	log.WithFields(log.Fields{
+		"books":   len(books),
+		"version": indexVersion,
+	}).Info("building search index")
+
+	for start := 0; start < len(books); start += indexBatchSize {
+		end := start + indexBatchSize
+		if end > len(books) {
+			end = len(books)
+		}
+		err = db.indexBatch(index, books[start:end], len(books))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *stormDB) saveIndexSettings(name, checksum string) error {
+	err := db.SaveSetting(indexPathSetting, name)
+	if err != nil {
+		return err
+	}
+	err = db.SaveSetting(indexMappingSetting, checksum)
+	if err != nil {
+		return err
+	}
+	return db.SaveSetting(indexVersionSetting, indexVersion)
+}
+
+// countDownloads counts the downloads of every book again
+func (db *stormDB) countDownloads() error {
+	var downloads []download
+	err := db.db.All(&downloads)
+	if err != nil && err != storm.ErrNotFound {
+		return fmt.Errorf("Unable to get downloads from db: %w", err)
+	}
+	counts := make(map[string]int)
+	for _, dl := range downloads {
+		counts[dl.Book]++
+	}
+	for hash, count := range counts {
+		err = db.db.Set("downloadcounts", hash, count)
+		if err != nil {
+			return fmt.Errorf("Unable to store download count: %w", err)
+		}
+	}
+	return nil
+}
+
+// searchFields maps the fields of a search query to the fields in the index
+var searchFields = map[string][]string{
+	"author": {"Author", "Authors"},
+	"title":  {"Title"},
+	"series": {"Series"},
+	"lang":   {"Language"},
+	"format": {"Formats"},
+	"added":  {"Added"},
+	// imported is not a field users can search, saved searches use it
+	"imported": {"Imported"},
+}
+
+// bleveQuery turns a parsed search query into a bleve query, free text is
+// matched with the given fuzziness
+func bleveQuery(q *booksing.Query, fuzziness int) query.Query {
+	var must, mustNot []query.Query
+	if q.IsEmpty() {
+		return bleve.NewMatchAllQuery()
+	}
+	for _, t := range q.Terms {
+		var tq query.Query
+		switch t.Field {
+		case "":
+			tq = textQuery(t, nil, fuzziness)
+		case "author", "title", "series":
+			tq = textQuery(t, searchFields[t.Field], 0)
+		case "lang", "format":
+			term := bleve.NewTermQuery(t.Value)
+			term.SetField(searchFields[t.Field][0])
+			tq = term
+		case "added", "imported":
+			inclusive, exclusive := true, false
+			dates := bleve.NewDateRangeInclusiveQuery(t.Start, t.End, &inclusive, &exclusive)
+			dates.SetField(searchFields[t.Field][0])
+			tq = dates
+		case "shelf":
+			tq = bleve.NewDocIDQuery(t.Hashes)
+		}
+		if t.Negate {
+			mustNot = append(mustNot, tq)
+		} else {
+			must = append(must, tq)
+		}
+	}
+
+	if len(mustNot) == 0 {
+		return bleve.NewConjunctionQuery(must...)
+	}
+	if len(must) == 0 {
+		must = append(must, bleve.NewMatchAllQuery())
+	}
+	b := bleve.NewBooleanQuery()
+	b.AddMust(must...)
+	b.AddMustNot(mustNot...)
+	return b
+}
+
+// textQuery matches all words of a term, or the exact phrase when quoted, in
+// any of the fields or all text fields when fields is empty. The language of
+// the books is unknown, so the term is analyzed like every language is.
+func textQuery(t booksing.QueryTerm, fields []string, fuzziness int) query.Query {
+	var queries []query.Query
+	for _, f := range textFields {
+		if len(fields) > 0 && !contains(fields, f.name) {
+			continue
+		}
+		analyzers := []string{nameAnalyzer}
+		if f.byLang {
+			analyzers = []string{standard.Name}
+			for _, a := range languageAnalyzers {
+				analyzers = append(analyzers, a)
+			}
+		}
+		for _, a := range analyzers {
+			if t.Phrase {
+				phrase := bleve.NewMatchPhraseQuery(t.Value)
+				phrase.SetField(f.name)
+				phrase.Analyzer = a
+				phrase.SetBoost(f.boost)
+				queries = append(queries, phrase)
+				continue
+			}
+			match := bleve.NewMatchQuery(t.Value)
+			match.SetField(f.name)
+			match.Analyzer = a
+			match.SetBoost(f.boost)
+			match.SetOperator(query.MatchQueryOperatorAnd)
+			match.SetFuzziness(fuzziness)
+			queries = append(queries, match)
+		}
+	}
+	return bleve.NewDisjunctionQuery(queries...)
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+// hasFreeText reports whether a query has terms that aren't tied to a field
+func hasFreeText(q *booksing.Query) bool {
+	for _, t := range q.Terms {
+		if t.Field == "" && !t.Negate && !t.Phrase {
+			return true
+		}
+	}
+	return false
+}
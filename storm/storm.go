@@ -3,17 +3,27 @@ package storm
 import (
 	"fmt"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
 	"github.com/blevesearch/bleve"
 	"github.com/gnur/booksing"
 	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
 )
 
 type stormDB struct {
 	db *storm.DB
-	in bleve.Index
+	in bleve.IndexAlias
+
+	// the search index that is in use and the directory it is stored in,
+	// the alias points to it until a rebuild swaps in a new one
+	dir         string
+	current     bleve.Index
+	currentPath string
+	rebuild     rebuild
 }
 
 type download = booksing.Download
@@ -22,18 +32,6 @@ type RefreshResult = booksing.RefreshResult
 func New(path string) (*stormDB, error) {
 
 	stormPath := filepath.Join(path, "booksing.db")
-	blevePath := filepath.Join(path, "search.bleve")
-
-	bleveIndex, err := bleve.Open(blevePath)
-	if err == bleve.ErrorIndexPathDoesNotExist {
-		bleveIndex, err = bleve.New(blevePath, bleve.NewIndexMapping())
-		if err != nil {
-			return nil, err
-		}
-	} else if err != nil {
-		log.Fatal(err)
-		return nil, err
-	}
 
 	db, err := storm.Open(stormPath)
 	if err != nil {
@@ -45,18 +43,48 @@ func New(path string) (*stormDB, error) {
 
 	database := stormDB{
 		db: db,
-		in: bleveIndex,
+	}
+
+	err = database.openIndex(path)
+	if err != nil {
+		log.Fatal(err)
+		return nil, err
 	}
 
 	return &database, nil
 }
 
 func (db *stormDB) Close() {
+	db.current.Close()
 	db.db.Close()
 }
 
 func (db *stormDB) AddDownload(dl download) error {
-	return db.db.Save(&dl)
+	err := db.db.Save(&dl)
+	if err != nil {
+		return err
+	}
+	err = db.db.Set("downloadcounts", dl.Book, db.GetDownloadCount(dl.Book)+1)
+	if err != nil {
+		return fmt.Errorf("Unable to store download count: %w", err)
+	}
+
+	//the download count is used to sort search results
+	b, err := db.GetBook(dl.Book)
+	if err != nil {
+		return nil
+	}
+	return db.indexBook(*b)
+}
+
+// GetDownloadCount returns how often a book has been downloaded
+func (db *stormDB) GetDownloadCount(hash string) int {
+	var count int
+	err := db.db.Get("downloadcounts", hash, &count)
+	if err != nil {
+		return 0
+	}
+	return count
 }
 
 func (db *stormDB) GetDownloads(limit int) ([]download, error) {
@@ -174,17 +202,211 @@ func (db *stormDB) HasHash(h string) (bool, error) {
 	return b, err
 }
 
+func (db *stormDB) DeleteHash(h string) error {
+	err := db.db.Delete("hashes", h)
+	if err == storm.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+func (db *stormDB) GetHashes() ([]string, error) {
+	var hashes []string
+	err := db.db.Bolt.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("hashes"))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			if strings.HasPrefix(string(k), "__storm") {
+				return nil
+			}
+			hashes = append(hashes, string(k))
+			return nil
+		})
+	})
+	return hashes, err
+}
+
+// AddMergedHash remembers that the book with hash was merged into another
+// book, so new imports of it can find that book
+func (db *stormDB) AddMergedHash(hash, into string) error {
+	return db.db.Set("merged", hash, into)
+}
+
+// GetMergedHash returns the hash of the book that the book with hash was
+// merged into
+func (db *stormDB) GetMergedHash(hash string) (string, error) {
+	var into string
+	err := db.db.Get("merged", hash, &into)
+	if err == storm.ErrNotFound {
+		return "", booksing.ErrNotFound
+	}
+	return into, err
+}
+
+// GetSetting reads a setting that is stored with the library into v
+func (db *stormDB) GetSetting(key string, v interface{}) error {
+	err := db.db.Get("settings", key, v)
+	if err == storm.ErrNotFound {
+		return booksing.ErrNotFound
+	}
+	return err
+}
+
+func (db *stormDB) SaveSetting(key string, v interface{}) error {
+	return db.db.Set("settings", key, v)
+}
+
+func (db *stormDB) SetBookCount(count int) error {
+	stats := dbBookCount{
+		ID:    "total",
+		Count: count,
+	}
+	err := db.db.Save(&stats)
+	if err != nil {
+		return fmt.Errorf("Unable to store total stats in db: %w", err)
+	}
+	return nil
+}
+
+func (db *stormDB) SaveFsckReport(r *booksing.FsckReport) error {
+	return db.db.Save(r)
+}
+
+func (db *stormDB) GetLastFsckReport(integrity bool) (*booksing.FsckReport, error) {
+	var reports []booksing.FsckReport
+	err := db.db.Select(q.Eq("Integrity", integrity)).Reverse().Limit(1).Find(&reports)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+	if len(reports) == 0 {
+		return nil, booksing.ErrNotFound
+	}
+	return &reports[0], nil
+}
+
 type dbBookCount struct {
 	ID    string `storm:"unique,index"`
 	Count int
 }
 
+// authorBook links a book to one of its authors, so the books of an author
+// can be found without going through the search index
+type authorBook struct {
+	ID       string `storm:"id"`
+	AuthorID string `storm:"index"`
+	Hash     string `storm:"index"`
+}
+
 func (db *stormDB) AddBook(b booksing.Book) error {
-	err := db.in.Index(b.Hash, b)
+	err := db.indexBook(b)
+	if err != nil {
+		return err
+	}
+	err = db.db.Save(&b)
 	if err != nil {
 		return err
 	}
-	return db.db.Save(&b)
+	for _, sum := range b.Checksums() {
+		err = db.db.Set("checksums", sum, b.Hash)
+		if err != nil {
+			return err
+		}
+	}
+	return db.linkAuthors(b.Hash, b.AuthorIDs)
+}
+
+// linkAuthors replaces the author links of a book with links to authorIDs
+func (db *stormDB) linkAuthors(hash string, authorIDs []string) error {
+	var links []authorBook
+	err := db.db.Find("Hash", hash, &links)
+	if err != nil && err != storm.ErrNotFound {
+		return fmt.Errorf("Unable to get author links from db: %w", err)
+	}
+	for i := range links {
+		err = db.db.DeleteStruct(&links[i])
+		if err != nil {
+			return fmt.Errorf("Unable to delete author link from db: %w", err)
+		}
+	}
+	for _, id := range authorIDs {
+		err = db.db.Save(&authorBook{
+			ID:       id + "/" + hash,
+			AuthorID: id,
+			Hash:     hash,
+		})
+		if err != nil {
+			return fmt.Errorf("Unable to store author link in db: %w", err)
+		}
+	}
+	return nil
+}
+
+// ClaimChecksum records that the file with checksum sum belongs to the book
+// with hash unless another book already has it, in one transaction so two
+// copies of a file that are imported at the same time can't both claim it.
+// The book that has the file is returned, claimed is false when that is not
+// a new claim.
+func (db *stormDB) ClaimChecksum(sum, hash string) (string, bool, error) {
+	tx, err := db.db.Begin(true)
+	if err != nil {
+		return "", false, fmt.Errorf("Unable to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var owner string
+	err = tx.Get("checksums", sum, &owner)
+	if err == nil {
+		var b booksing.Book
+		err = tx.One("Hash", owner, &b)
+		if err == storm.ErrNotFound || (err == nil && hasChecksum(&b, sum)) {
+			//the owner is either still being stored or still has the file
+			return owner, false, nil
+		} else if err != nil {
+			return "", false, fmt.Errorf("Unable to get book: %w", err)
+		}
+	} else if err != storm.ErrNotFound {
+		return "", false, fmt.Errorf("Unable to get checksum: %w", err)
+	}
+
+	err = tx.Set("checksums", sum, hash)
+	if err != nil {
+		return "", false, fmt.Errorf("Unable to store checksum: %w", err)
+	}
+	return hash, true, tx.Commit()
+}
+
+func hasChecksum(b *booksing.Book, sum string) bool {
+	for _, s := range b.Checksums() {
+		if s == sum {
+			return true
+		}
+	}
+	return false
+}
+
+// GetBookByChecksum returns the book that has a file with the given checksum
+func (db *stormDB) GetBookByChecksum(sum string) (*booksing.Book, error) {
+	var hash string
+	err := db.db.Get("checksums", sum, &hash)
+	if err == storm.ErrNotFound {
+		return nil, booksing.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	b, err := db.GetBook(hash)
+	if err != nil {
+		return nil, err
+	}
+	if hasChecksum(b, sum) {
+		return b, nil
+	}
+
+	//the file has been removed from the book since
+	_ = db.db.Delete("checksums", sum)
+	return nil, booksing.ErrNotFound
 }
 
 func (db *stormDB) GetBook(hash string) (*booksing.Book, error) {
@@ -196,6 +418,30 @@ func (db *stormDB) GetBook(hash string) (*booksing.Book, error) {
 	return &b, err
 }
 
+// GetBooksByHash returns the books with the given hashes in the same order,
+// in a single transaction, hashes that are not found are skipped
+func (db *stormDB) GetBooksByHash(hashes []string) ([]booksing.Book, error) {
+	tx, err := db.db.Begin(false)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	books := make([]booksing.Book, 0, len(hashes))
+	for _, hash := range hashes {
+		var b booksing.Book
+		err = tx.One("Hash", hash, &b)
+		if err == storm.ErrNotFound {
+			log.WithField("hash", hash).Warning("book not found in db")
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("Unable to get book: %w", err)
+		}
+		books = append(books, b)
+	}
+	return books, nil
+}
+
 func (db *stormDB) AddBooks(books []booksing.Book, sync bool) error {
 	var err error
 	var errs []error
@@ -213,56 +459,272 @@ func (db *stormDB) AddBooks(books []booksing.Book, sync bool) error {
 	return nil
 }
 
-func (db *stormDB) DeleteBook(hash string) error {
-	//todo remove from bleve
-	return db.in.Delete(hash)
+func (db *stormDB) AddDuplicate(d *booksing.Duplicate) error {
+	return db.db.Save(d)
 }
 
-func (db *stormDB) GetBooks(q string, limit, offset int64) (*booksing.SearchResult, error) {
+func (db *stormDB) GetDuplicate(id int) (*booksing.Duplicate, error) {
+	var d booksing.Duplicate
+	err := db.db.One("ID", id, &d)
+	if err == storm.ErrNotFound {
+		return &d, booksing.ErrNotFound
+	}
+	return &d, err
+}
 
-	var books []booksing.Book
+func (db *stormDB) GetDuplicates() ([]booksing.Duplicate, error) {
+	var dups []booksing.Duplicate
+	err := db.db.All(&dups)
+	return dups, err
+}
+
+func (db *stormDB) DeleteDuplicate(id int) error {
+	return db.db.DeleteStruct(&booksing.Duplicate{ID: id})
+}
 
-	if q == "" {
-		return db.recentBooks()
+func (db *stormDB) SaveSimilarGroup(g *booksing.SimilarGroup) error {
+	return db.db.Save(g)
+}
+
+func (db *stormDB) GetSimilarGroup(id string) (*booksing.SimilarGroup, error) {
+	var g booksing.SimilarGroup
+	err := db.db.One("ID", id, &g)
+	if err == storm.ErrNotFound {
+		return &g, booksing.ErrNotFound
 	}
+	return &g, err
+}
 
-	//query := bleve.NewQueryStringQuery(q)
-	query := bleve.NewFuzzyQuery(q)
-	searchRequest := bleve.NewSearchRequest(query)
-	searchRequest.From = int(offset)
-	searchRequest.Size = int(limit)
-	res, _ := db.in.Search(searchRequest)
-	if res.Total == 0 {
-		query := bleve.NewQueryStringQuery(q)
-		searchRequest = bleve.NewSearchRequest(query)
-		searchRequest.From = int(offset)
-		searchRequest.Size = int(limit)
-		res, _ = db.in.Search(searchRequest)
+func (db *stormDB) GetSimilarGroups() ([]booksing.SimilarGroup, error) {
+	var groups []booksing.SimilarGroup
+	err := db.db.All(&groups)
+	return groups, err
+}
+
+func (db *stormDB) DeleteSimilarGroup(id string) error {
+	return db.db.DeleteStruct(&booksing.SimilarGroup{ID: id})
+}
+
+func (db *stormDB) SaveAuthor(a *booksing.Author) error {
+	return db.db.Save(a)
+}
+
+func (db *stormDB) GetAuthor(id string) (*booksing.Author, error) {
+	var a booksing.Author
+	err := db.db.One("ID", id, &a)
+	if err == storm.ErrNotFound {
+		return &a, booksing.ErrNotFound
+	}
+	return &a, err
+}
+
+func (db *stormDB) GetAuthors() ([]booksing.Author, error) {
+	var authors []booksing.Author
+	err := db.db.AllByIndex("SortName", &authors)
+	if err == storm.ErrNotFound {
+		return authors, nil
+	}
+	return authors, err
+}
+
+func (db *stormDB) DeleteAuthor(id string) error {
+	return db.db.DeleteStruct(&booksing.Author{ID: id})
+}
+
+// GetAuthorBookCounts returns the number of books of every author by ID
+func (db *stormDB) GetAuthorBookCounts() (map[string]int, error) {
+	var links []authorBook
+	err := db.db.All(&links)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+	counts := make(map[string]int)
+	for _, l := range links {
+		counts[l.AuthorID]++
+	}
+	return counts, nil
+}
+
+// GetAuthorBooks returns all books of an author
+func (db *stormDB) GetAuthorBooks(id string) ([]booksing.Book, error) {
+	var links []authorBook
+	err := db.db.Find("AuthorID", id, &links)
+	if err == storm.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
 	}
 
+	hashes := make([]string, 0, len(links))
+	for _, l := range links {
+		hashes = append(hashes, l.Hash)
+	}
+	return db.GetBooksByHash(hashes)
+}
+
+func (db *stormDB) SaveAuthorAlias(a *booksing.AuthorAlias) error {
+	return db.db.Save(a)
+}
+
+func (db *stormDB) GetAuthorAlias(id string) (*booksing.AuthorAlias, error) {
+	var a booksing.AuthorAlias
+	err := db.db.One("ID", id, &a)
+	if err == storm.ErrNotFound {
+		return &a, booksing.ErrNotFound
+	}
+	return &a, err
+}
+
+func (db *stormDB) GetAuthorAliases() ([]booksing.AuthorAlias, error) {
+	var aliases []booksing.AuthorAlias
+	err := db.db.All(&aliases)
+	if err == storm.ErrNotFound {
+		return aliases, nil
+	}
+	return aliases, err
+}
+
+func (db *stormDB) DeleteAuthorAlias(id string) error {
+	return db.db.DeleteStruct(&booksing.AuthorAlias{ID: id})
+}
+
+func (db *stormDB) GetAllBooks() ([]booksing.Book, error) {
+	var books []booksing.Book
+	err := db.db.All(&books)
+	return books, err
+}
+
+func (db *stormDB) GetIndexedHashes() ([]string, error) {
+	count, err := db.in.DocCount()
+	if err != nil {
+		return nil, err
+	}
+	searchRequest := bleve.NewSearchRequest(bleve.NewMatchAllQuery())
+	searchRequest.Size = int(count)
+	res, err := db.in.Search(searchRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]string, 0, len(res.Hits))
 	for _, hit := range res.Hits {
-		b, err := db.GetBook(hit.ID)
-		if err != nil {
-			fmt.Println("Could not get book")
+		hashes = append(hashes, hit.ID)
+	}
+	return hashes, nil
+}
+
+// DeleteBook removes a book from the search index, the database and the
+// stored hashes so it can be imported again later.
+func (db *stormDB) DeleteBook(hash string) error {
+	b, err := db.GetBook(hash)
+	if err == nil {
+		for _, sum := range b.Checksums() {
+			//the file may have been moved to another book
+			var owner string
+			err = db.db.Get("checksums", sum, &owner)
+			if err != nil || owner != hash {
+				continue
+			}
+			err = db.db.Delete("checksums", sum)
+			if err != nil && err != storm.ErrNotFound {
+				return fmt.Errorf("Unable to delete checksum from db: %w", err)
+			}
 		}
+	}
 
-		books = append(books, *b)
+	err = db.unindexBook(hash)
+	if err != nil {
+		return fmt.Errorf("Unable to delete book from search index: %w", err)
+	}
+	err = db.db.DeleteStruct(&booksing.Book{Hash: hash})
+	if err != nil && err != storm.ErrNotFound {
+		return fmt.Errorf("Unable to delete book from db: %w", err)
+	}
+	err = db.linkAuthors(hash, nil)
+	if err != nil {
+		return err
+	}
+	err = db.DeleteHash(hash)
+	if err != nil {
+		return fmt.Errorf("Unable to delete hash from db: %w", err)
 	}
+	return nil
+}
 
-	return &booksing.SearchResult{
-		Items: books,
-		Total: int64(res.Total),
-	}, nil
+func (db *stormDB) AddTrashedBook(t booksing.TrashedBook) error {
+	return db.db.Save(&t)
 }
 
-func (db *stormDB) recentBooks() (*booksing.SearchResult, error) {
+func (db *stormDB) GetTrashedBook(id string) (*booksing.TrashedBook, error) {
+	var t booksing.TrashedBook
+	err := db.db.One("ID", id, &t)
+	if err == storm.ErrNotFound {
+		return &t, booksing.ErrNotFound
+	}
+	setTrashID(&t)
+	return &t, err
+}
 
-	var books []booksing.Book
+func (db *stormDB) GetTrashedBooks() ([]booksing.TrashedBook, error) {
+	var trashed []booksing.TrashedBook
+	err := db.db.AllByIndex("Deleted", &trashed, storm.Reverse())
+	if err == storm.ErrNotFound {
+		return trashed, nil
+	}
+	for i := range trashed {
+		setTrashID(&trashed[i])
+	}
+	return trashed, err
+}
+
+// setTrashID fills in the ID of books that were trashed before they had one,
+// those are stored under their hash
+func setTrashID(t *booksing.TrashedBook) {
+	if t.ID == "" {
+		t.ID = t.Hash
+	}
+}
+
+func (db *stormDB) DeleteTrashedBook(id string) error {
+	return db.db.DeleteStruct(&booksing.TrashedBook{ID: id})
+}
+
+// GetBooks returns the books that match the query, free text is matched
+// fuzzily when nothing matches exactly
+func (db *stormDB) GetBooks(q *booksing.Query, limit, offset int64) (*booksing.SearchResult, error) {
+	searchRequest := bleve.NewSearchRequest(bleveQuery(q, 0))
+	searchRequest.From = int(offset)
+	searchRequest.Size = int(limit)
+	searchRequest.SortByCustom(sortOrder(q.Sort))
+	addFacets(searchRequest)
+	if !q.IsEmpty() {
+		addHighlight(searchRequest)
+	}
+	res, err := db.in.Search(searchRequest)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to search: %w", err)
+	}
+	if res.Total == 0 && hasFreeText(q) {
+		searchRequest.Query = bleveQuery(q, 1)
+		res, err = db.in.Search(searchRequest)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to search: %w", err)
+		}
+	}
 
-	err := db.db.AllByIndex("Added", &books, storm.Limit(20), storm.Reverse())
+	hashes := make([]string, 0, len(res.Hits))
+	for _, hit := range res.Hits {
+		hashes = append(hashes, hit.ID)
+	}
+	books, err := db.GetBooksByHash(hashes)
+	if err != nil {
+		return nil, err
+	}
 
 	return &booksing.SearchResult{
-		Items: books,
-		Total: int64(len(books)),
-	}, err
+		Items:      books,
+		Total:      int64(res.Total),
+		Facets:     searchFacets(res),
+		Highlights: searchHighlights(res),
+	}, nil
 }
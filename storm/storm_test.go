@@ -0,0 +1,460 @@
+package storm
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/gnur/booksing"
+	log "github.com/sirupsen/logrus"
+)
+
+func newTestDB(t testing.TB, count int) *stormDB {
+	log.SetLevel(log.WarnLevel)
+	db, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+
+	added := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	var books []booksing.Book
+	for i := 0; i < count; i++ {
+		books = append(books, booksing.Book{
+			Hash:     fmt.Sprintf("book%04d", i),
+			Title:    fmt.Sprintf("Title %d", i),
+			Author:   fmt.Sprintf("Author %d", i%50),
+			Language: "nl",
+			Added:    added.Add(time.Duration(i) * time.Hour),
+		})
+	}
+	err = db.AddBooks(books, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func Test_getBooksByHash(t *testing.T) {
+	db := newTestDB(t, 3)
+
+	books, err := db.GetBooksByHash([]string{"book0002", "missing", "book0000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, b := range books {
+		got = append(got, b.Hash)
+	}
+	if fmt.Sprint(got) != "[book0002 book0000]" {
+		t.Errorf("GetBooksByHash() = %v, want [book0002 book0000]", got)
+	}
+}
+
+func Test_deleteMergedBook(t *testing.T) {
+	db := newTestDB(t, 0)
+	keep := booksing.Book{Hash: "keep", Title: "Garen", Author: "Anna Bakker", Checksum: "sum-keep"}
+	other := booksing.Book{Hash: "other", Title: "Garen", Author: "Anna Baker", Checksum: "sum-other"}
+	err := db.AddBooks([]booksing.Book{keep, other}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keep.Files = append(keep.Files, other.PrimaryFile())
+	err = db.AddBooks([]booksing.Book{keep}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.DeleteBook(other.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, sum := range []string{"sum-keep", "sum-other"} {
+		b, err := db.GetBookByChecksum(sum)
+		if err != nil {
+			t.Errorf("GetBookByChecksum(%s) after merge: %v", sum, err)
+		} else if b.Hash != "keep" {
+			t.Errorf("GetBookByChecksum(%s) = %s, want keep", sum, b.Hash)
+		}
+	}
+}
+
+func Test_claimChecksum(t *testing.T) {
+	db := newTestDB(t, 0)
+	err := db.AddBooks([]booksing.Book{{Hash: "stored", Title: "Garen", Checksum: "sum-stored"}}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		sum, hash string
+		owner     string
+		claimed   bool
+	}{
+		{"sum-new", "first", "first", true},
+		// the first book is not stored yet but still owns the file
+		{"sum-new", "second", "first", false},
+		{"sum-stored", "other", "stored", false},
+		{"sum-stored", "stored", "stored", false},
+	}
+	for _, tt := range tests {
+		owner, claimed, err := db.ClaimChecksum(tt.sum, tt.hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if owner != tt.owner || claimed != tt.claimed {
+			t.Errorf("ClaimChecksum(%s, %s) = %s, %v, want %s, %v", tt.sum, tt.hash, owner, claimed, tt.owner, tt.claimed)
+		}
+	}
+
+	// a book that no longer has the file gives it up
+	err = db.AddBooks([]booksing.Book{{Hash: "stored", Title: "Garen", Checksum: "sum-changed"}}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner, claimed, err := db.ClaimChecksum("sum-stored", "other")
+	if err != nil || owner != "other" || !claimed {
+		t.Errorf("ClaimChecksum() of a released file = %s, %v, %v, want other, true", owner, claimed, err)
+	}
+}
+
+func Test_countBooksSince(t *testing.T) {
+	db := newTestDB(t, 0)
+	visit := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	err := db.AddBooks([]booksing.Book{
+		// an old file that was imported after the visit
+		{Hash: "old-file", Title: "Garen", Added: visit.AddDate(-5, 0, 0), Imported: visit.Add(time.Hour)},
+		{Hash: "seen", Title: "Herfst", Added: visit.Add(time.Hour), Imported: visit.Add(-time.Hour)},
+	}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q, _ := booksing.ParseQuery("")
+	got, err := db.CountBooks(q.Since(visit))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 1 {
+		t.Errorf("CountBooks() = %d, want 1", got)
+	}
+}
+
+func Test_renameHashes(t *testing.T) {
+	db := newTestDB(t, 0)
+	for _, h := range []string{"keep", "merged", "merged", "a", "b"} {
+		err := db.AddDownload(download{Book: h, User: "bob"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a and b swap hashes, merged is merged into keep
+	err := db.RenameHashes(map[string]string{"merged": "keep", "a": "b", "b": "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]int{"keep": 3, "merged": 0, "a": 1, "b": 1}
+	for h, count := range want {
+		if got := db.GetDownloadCount(h); got != count {
+			t.Errorf("GetDownloadCount(%s) = %d, want %d", h, got, count)
+		}
+	}
+	dls, err := db.GetDownloads(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dl := range dls {
+		if dl.Book == "merged" {
+			t.Errorf("download %d still points to the merged book", dl.ID)
+		}
+	}
+}
+
+func Test_renameHashBuckets(t *testing.T) {
+	db := newTestDB(t, 0)
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	must(db.AddDuplicate(&booksing.Duplicate{Hash: "old", Candidate: booksing.Book{Hash: "old"}}))
+	must(db.AddTrashedBook(booksing.TrashedBook{ID: "old-1", Hash: "old", Book: booksing.Book{Hash: "old"}}))
+	must(db.AddMergedHash("gone", "old"))
+	must(db.AddMergedHash("new", "other"))
+	must(db.SaveSimilarGroup(&booksing.SimilarGroup{ID: booksing.GroupID([]string{"old", "other"}), Hashes: []string{"old", "other"}}))
+	must(db.SaveSimilarGroup(&booksing.SimilarGroup{ID: booksing.GroupID([]string{"old", "new"}), Hashes: []string{"old", "new"}}))
+	_, err := db.AddNotification(booksing.Notification{ID: booksing.NotificationID(1, "old"), User: "bob", Search: 1, Book: "old"})
+	must(err)
+
+	must(db.RenameHashes(map[string]string{"old": "new"}))
+
+	dups, err := db.GetDuplicates()
+	must(err)
+	if len(dups) != 1 || dups[0].Hash != "new" || dups[0].Candidate.Hash != "new" {
+		t.Errorf("duplicates after rename = %+v", dups)
+	}
+	trashed, err := db.GetTrashedBook("old-1")
+	must(err)
+	if trashed.Hash != "new" || trashed.Book.Hash != "new" {
+		t.Errorf("trashed book after rename = %s, %s", trashed.Hash, trashed.Book.Hash)
+	}
+	if into, err := db.GetMergedHash("gone"); err != nil || into != "new" {
+		t.Errorf("GetMergedHash(gone) = %s, %v, want new", into, err)
+	}
+	if _, err := db.GetMergedHash("new"); err != booksing.ErrNotFound {
+		t.Errorf("GetMergedHash(new) error = %v, the hash belongs to a book now", err)
+	}
+	groups, err := db.GetSimilarGroups()
+	must(err)
+	if len(groups) != 1 || groups[0].ID != booksing.GroupID([]string{"new", "other"}) {
+		t.Errorf("similar groups after rename = %+v", groups)
+	}
+	notifications, err := db.GetNotifications("bob", 10)
+	must(err)
+	if len(notifications) != 1 || notifications[0].Book != "new" || notifications[0].ID != booksing.NotificationID(1, "new") {
+		t.Errorf("notifications after rename = %+v", notifications)
+	}
+}
+
+func Test_searchMapping(t *testing.T) {
+	db := newTestDB(t, 0)
+	err := db.AddBooks([]booksing.Book{
+		{Hash: "title", Title: "De grachten van Amsterdam", Author: "Anna Bakker", Language: "nl"},
+		{Hash: "description", Title: "Herfst", Author: "Jan Visser", Language: "nl", Description: "Een verhaal over een huis aan de gracht"},
+		{Hash: "author", Title: "Ik reis alleen", Author: "Samuel Bjørk", Language: "nl"},
+	}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		q    string
+		want string
+	}{
+		{"gracht", "[title description]"},
+		{"grachten", "[title description]"},
+		{"huis", "[description]"},
+		{"author:bjork", "[author]"},
+		{"title:gracht", "[title]"},
+		{"Bakker", "[title]"},
+		{"book0000", "[]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.q, func(t *testing.T) {
+			q, err := booksing.ParseQuery(tt.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = q.SetSort("")
+			res, err := db.GetBooks(q, 10, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, b := range res.Items {
+				got = append(got, b.Hash)
+			}
+			if fmt.Sprint(got) != tt.want {
+				t.Errorf("GetBooks(%s) = %v, want %v", tt.q, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_rebuildIndex(t *testing.T) {
+	db := newTestDB(t, 3)
+	oldPath := db.currentPath
+
+	err := db.RebuildIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := db.GetIndexProgress(); p.Running || p.Done != 3 || p.Total != 3 {
+		t.Errorf("GetIndexProgress() = %+v, want 3 of 3 books done", p)
+	}
+	if db.currentPath == oldPath {
+		t.Errorf("RebuildIndex() kept using %s", oldPath)
+	}
+	if _, err := os.Stat(oldPath); !os.IsNotExist(err) {
+		t.Errorf("RebuildIndex() did not remove %s", oldPath)
+	}
+
+	err = db.AddBook(booksing.Book{Hash: "new", Title: "Nieuw", Author: "Anna Bakker", Language: "nl"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, _ := booksing.ParseQuery("")
+	_ = q.SetSort("")
+	res, err := db.GetBooks(q, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Total != 4 {
+		t.Errorf("GetBooks() found %d books after rebuild, want 4", res.Total)
+	}
+}
+
+func Test_matchBooks(t *testing.T) {
+	db := newTestDB(t, 10)
+
+	q, _ := booksing.ParseQuery(`author:"Author 1"`)
+	got, err := db.MatchBooks(q, []string{"book0000", "book0001", "book0002"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got) != "[book0001]" {
+		t.Errorf("MatchBooks() = %v, want [book0001]", got)
+	}
+
+	count, err := db.CountBooks(q.Since(time.Date(2020, 1, 1, 5, 0, 0, 0, time.UTC)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("CountBooks() = %d, want 0 books added since book0001", count)
+	}
+}
+
+func Test_relatedBooks(t *testing.T) {
+	db := newTestDB(t, 20)
+	err := db.AddBooks([]booksing.Book{
+		{Hash: "rose1", Title: "De bed & breakfast in Rose Harbor", Author: "Debbie Macomber", Series: "Rose Harbor", Language: "nl"},
+		{Hash: "rose2", Title: "Een nieuw begin in Rose Harbor", Author: "Debbie Macomber", Series: "Rose Harbor", Language: "nl"},
+		{Hash: "rose3", Title: "Rose Harbor in bloei", Author: "Debbie Macomber", Series: "Rose Harbor", Language: "nl"},
+		{Hash: "knit", Title: "Breibabes", Author: "Debbie Macomber", Language: "nl", Description: "Een wolwinkel in Seattle"},
+		{Hash: "yarn", Title: "Garen", Author: "Anna Bakker", Language: "nl", Description: "Een nieuwe wolwinkel"},
+	}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		hash    string
+		exclude []string
+		want    string
+	}{
+		{"rose1", nil, "[knit rose2 rose3]"},
+		{"rose1", []string{"rose3"}, "[knit rose2]"},
+		{"knit", nil, "[rose1 rose2 rose3 yarn]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.hash, func(t *testing.T) {
+			books, err := db.GetRelatedBooks(tt.hash, tt.exclude, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, b := range books {
+				got = append(got, b.Hash)
+			}
+			sort.Strings(got)
+			if fmt.Sprint(got) != tt.want {
+				t.Errorf("GetRelatedBooks() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// BenchmarkGetBooks measures the latency of a search page of increasing size.
+// The latency is not flat, it grows with the page size because bleve loads
+// and sorts more hits, fetching the books from storm is only a small part of
+// it (see BenchmarkFetchPage).
+func BenchmarkGetBooks(b *testing.B) {
+	db := newTestDB(b, 500)
+	for _, limit := range []int64{10, 50, 200} {
+		b.Run(fmt.Sprintf("limit=%d", limit), func(b *testing.B) {
+			q, _ := booksing.ParseQuery("lang:nl")
+			_ = q.SetSort("added")
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				res, err := db.GetBooks(q, limit, 0)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if int64(len(res.Items)) != limit {
+					b.Fatalf("GetBooks() returned %d books, want %d", len(res.Items), limit)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkFetchPage compares fetching the books of a page in a single
+// transaction with fetching every book on its own, like search pages did
+// before. Both take about as long, a single transaction saves little.
+func BenchmarkFetchPage(b *testing.B) {
+	db := newTestDB(b, 500)
+	for _, limit := range []int{10, 50, 200} {
+		var hashes []string
+		for i := 0; i < limit; i++ {
+			hashes = append(hashes, fmt.Sprintf("book%04d", i))
+		}
+		b.Run(fmt.Sprintf("limit=%d/fetch=batch", limit), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				books, err := db.GetBooksByHash(hashes)
+				if err != nil || len(books) != limit {
+					b.Fatalf("GetBooksByHash() = %d books, %v", len(books), err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("limit=%d/fetch=perbook", limit), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for _, h := range hashes {
+					_, err := db.GetBook(h)
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkShelves compares setting the shelves of a page from the user of
+// the request with loading the user again for every book, like search pages
+// did before. This is where a page saves most, loading the user grows with
+// every book on the page.
+func BenchmarkShelves(b *testing.B) {
+	db := newTestDB(b, 200)
+	user := booksing.User{Name: "reader", IsAllowed: true, Bookmarks: make(map[string]booksing.Bookmark)}
+	for i := 0; i < 200; i += 3 {
+		user.SetShelf(fmt.Sprintf("book%04d", i), "star")
+	}
+	err := db.SaveUser(&user)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, limit := range []int{10, 50, 200} {
+		var hashes []string
+		for i := 0; i < limit; i++ {
+			hashes = append(hashes, fmt.Sprintf("book%04d", i))
+		}
+		books, err := db.GetBooksByHash(hashes)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(fmt.Sprintf("limit=%d/shelves=once", limit), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				user.SetIcons(books)
+			}
+		})
+		b.Run(fmt.Sprintf("limit=%d/shelves=perbook", limit), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for j := range books {
+					u, err := db.GetUser(user.Name)
+					if err != nil {
+						b.Fatal(err)
+					}
+					books[j].Icon = u.Icon(books[j].Hash)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package booksing
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Suggestion is a completion for a partially typed search, Filter is the
+// query term that searches for it
+type Suggestion struct {
+	Kind   string
+	Value  string
+	Books  int
+	Filter string
+}
+
+// suggestKinds are the kinds of suggestions in the order they are preferred
+var suggestKinds = []string{"author", "series", "title"}
+
+type suggestKey struct {
+	kind  string
+	value string
+}
+
+type suggestWord struct {
+	word string
+	key  suggestKey
+}
+
+// SuggestIndex is an in-memory index of the authors, series and titles of
+// all books, every word of a value can be completed
+type SuggestIndex struct {
+	mu     sync.RWMutex
+	values map[suggestKey]int
+	books  map[string][]suggestKey
+	words  []suggestWord
+	dirty  bool
+}
+
+// NewSuggestIndex returns an empty suggestion index
+func NewSuggestIndex() *SuggestIndex {
+	return &SuggestIndex{
+		values: make(map[suggestKey]int),
+		books:  make(map[string][]suggestKey),
+	}
+}
+
+// Add adds or updates the authors, series and title of a book
+func (s *SuggestIndex) Add(b Book) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(b.Hash)
+
+	authors := b.Authors
+	if len(authors) == 0 {
+		authors = []string{b.Author}
+	}
+	var keys []suggestKey
+	for _, a := range authors {
+		keys = append(keys, suggestKey{"author", a})
+	}
+	keys = append(keys, suggestKey{"series", b.Series}, suggestKey{"title", b.Title})
+
+	for _, k := range keys {
+		if IsPlaceholder(k.value) {
+			continue
+		}
+		s.values[k]++
+		s.books[b.Hash] = append(s.books[b.Hash], k)
+	}
+	s.dirty = true
+}
+
+// Remove removes a book from the index
+func (s *SuggestIndex) Remove(hash string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(hash)
+}
+
+func (s *SuggestIndex) remove(hash string) {
+	for _, k := range s.books[hash] {
+		s.values[k]--
+		if s.values[k] <= 0 {
+			delete(s.values, k)
+		}
+		s.dirty = true
+	}
+	delete(s.books, hash)
+}
+
+// Suggest returns at most limit values that have a word starting with q,
+// when there are not enough it adds values with a word that starts almost
+// like q
+func (s *SuggestIndex) Suggest(q string, limit int) []Suggestion {
+	q = Normalize(q)
+	if q == "" || limit <= 0 {
+		return nil
+	}
+	words := s.sortedWords()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	seen := make(map[suggestKey]bool)
+	var matches []suggestKey
+	for i := sort.Search(len(words), func(i int) bool { return words[i].word >= q }); i < len(words); i++ {
+		if !strings.HasPrefix(words[i].word, q) {
+			break
+		}
+		if k := words[i].key; !seen[k] && s.values[k] > 0 {
+			seen[k] = true
+			matches = append(matches, k)
+		}
+	}
+	suggestions := s.rank(matches, limit)
+	if len(suggestions) >= limit || len([]rune(q)) < 3 {
+		return suggestions
+	}
+
+	maxDistance := 1
+	if len([]rune(q)) >= 7 {
+		maxDistance = 2
+	}
+	var fuzzy []suggestKey
+	for _, w := range words {
+		if seen[w.key] || s.values[w.key] == 0 {
+			continue
+		}
+		prefix := []rune(w.word)
+		if len(prefix) > len([]rune(q)) {
+			prefix = prefix[:len([]rune(q))]
+		}
+		if Levenshtein(q, string(prefix)) <= maxDistance {
+			seen[w.key] = true
+			fuzzy = append(fuzzy, w.key)
+		}
+	}
+	return append(suggestions, s.rank(fuzzy, limit-len(suggestions))...)
+}
+
+// sortedWords returns every word start of every value, sorted, the list is
+// built again after the index changed
+func (s *SuggestIndex) sortedWords() []suggestWord {
+	s.mu.RLock()
+	if !s.dirty {
+		defer s.mu.RUnlock()
+		return s.words
+	}
+	s.mu.RUnlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var words []suggestWord
+	for k := range s.values {
+		norm := strings.Fields(Normalize(k.value))
+		for i := range norm {
+			words = append(words, suggestWord{
+				word: strings.Join(norm[i:], " "),
+				key:  k,
+			})
+		}
+	}
+	sort.Slice(words, func(i, j int) bool {
+		return words[i].word < words[j].word
+	})
+	s.words = words
+	s.dirty = false
+	return words
+}
+
+// rank orders keys by the number of books, then by kind and value
+func (s *SuggestIndex) rank(keys []suggestKey, limit int) []Suggestion {
+	kindOrder := make(map[string]int)
+	for i, k := range suggestKinds {
+		kindOrder[k] = i
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if s.values[a] != s.values[b] {
+			return s.values[a] > s.values[b]
+		}
+		if a.kind != b.kind {
+			return kindOrder[a.kind] < kindOrder[b.kind]
+		}
+		return a.value < b.value
+	})
+	if len(keys) > limit {
+		keys = keys[:limit]
+	}
+
+	var suggestions []Suggestion
+	for _, k := range keys {
+		suggestions = append(suggestions, Suggestion{
+			Kind:   k.kind,
+			Value:  k.value,
+			Books:  s.values[k],
+			Filter: QueryFilter(k.kind, k.value),
+		})
+	}
+	return suggestions
+}
@@ -0,0 +1,48 @@
+package booksing
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_suggestIndex(t *testing.T) {
+	s := NewSuggestIndex()
+	s.Add(Book{Hash: "1", Author: "Debbie Macomber", Title: "Rose Harbor in bloei", Series: "Rose Harbor"})
+	s.Add(Book{Hash: "2", Author: "Debbie Macomber", Title: "Het huis aan Rose Harbor", Series: "Rose Harbor"})
+	s.Add(Book{Hash: "3", Authors: []string{"Terry Pratchett", "Neil Gaiman"}, Title: "Good Omens"})
+	s.Add(Book{Hash: "4", Author: "Unknown", Title: "Macbeth"})
+
+	values := func(suggestions []Suggestion) []string {
+		var v []string
+		for _, s := range suggestions {
+			v = append(v, s.Kind+":"+s.Value)
+		}
+		return v
+	}
+
+	tests := []struct {
+		q    string
+		want []string
+	}{
+		{"mac", []string{"author:Debbie Macomber", "title:Macbeth"}},
+		{"rose har", []string{"series:Rose Harbor", "title:Het huis aan Rose Harbor", "title:Rose Harbor in bloei"}},
+		{"gaim", []string{"author:Neil Gaiman"}},
+		{"pratchet", []string{"author:Terry Pratchett"}},
+		{"macomer", []string{"author:Debbie Macomber"}},
+		{"unknown", nil},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.q, func(t *testing.T) {
+			if got := values(s.Suggest(tt.q, 5)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Suggest() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	s.Remove("1")
+	s.Add(Book{Hash: "2", Author: "Debbie Macomber", Title: "Thuiskomen in Rose Harbor"})
+	if got := values(s.Suggest("rose", 5)); !reflect.DeepEqual(got, []string{"title:Thuiskomen in Rose Harbor"}) {
+		t.Errorf("Suggest() after update = %q", got)
+	}
+}
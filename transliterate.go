@@ -0,0 +1,91 @@
+package booksing
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// unicodeUnsafe matches everything that isn't a letter, digit, mark, space or
+// dash in any script
+var unicodeUnsafe = regexp.MustCompile(`[^\p{L}\p{M}\p{N} -]+`)
+
+// maxNameLength is the maximum length in bytes of a single path segment,
+// most filesystems allow 255 bytes and the extension needs some room
+const maxNameLength = 200
+
+// transliterations holds the latin form of letters that don't decompose
+// into a latin letter and a diacritic
+var transliterations = map[rune]string{
+	'ø': "o", 'Ø': "O", 'ł': "l", 'Ł': "L", 'đ': "d", 'Đ': "D", 'ð': "d", 'Ð': "D",
+	'þ': "th", 'Þ': "Th", 'ß': "ss", 'æ': "ae", 'Æ': "Ae", 'œ': "oe", 'Œ': "Oe",
+	'ı': "i", 'ħ': "h", 'Ħ': "H", 'ŀ': "l", 'Ŀ': "L", 'ŋ': "ng", 'Ŋ': "Ng",
+
+	// cyrillic
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "yo", 'ж': "zh",
+	'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o",
+	'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts",
+	'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu",
+	'я': "ya", 'є': "ye", 'і': "i", 'ї': "yi", 'ґ': "g", 'ў': "u", 'ј': "j", 'љ': "lj",
+	'њ': "nj", 'ћ': "c", 'џ': "dz", 'ђ': "dj",
+	'А': "A", 'Б': "B", 'В': "V", 'Г': "G", 'Д': "D", 'Е': "E", 'Ё': "Yo", 'Ж': "Zh",
+	'З': "Z", 'И': "I", 'Й': "Y", 'К': "K", 'Л': "L", 'М': "M", 'Н': "N", 'О': "O",
+	'П': "P", 'Р': "R", 'С': "S", 'Т': "T", 'У': "U", 'Ф': "F", 'Х': "Kh", 'Ц': "Ts",
+	'Ч': "Ch", 'Ш': "Sh", 'Щ': "Shch", 'Ъ': "", 'Ы': "Y", 'Ь': "", 'Э': "E", 'Ю': "Yu",
+	'Я': "Ya", 'Є': "Ye", 'І': "I", 'Ї': "Yi", 'Ґ': "G", 'Ў': "U", 'Ј': "J", 'Љ': "Lj",
+	'Њ': "Nj", 'Ћ': "C", 'Џ': "Dz", 'Ђ': "Dj",
+
+	// greek, accented letters decompose to these
+	'α': "a", 'β': "v", 'γ': "g", 'δ': "d", 'ε': "e", 'ζ': "z", 'η': "i", 'θ': "th",
+	'ι': "i", 'κ': "k", 'λ': "l", 'μ': "m", 'ν': "n", 'ξ': "x", 'ο': "o", 'π': "p",
+	'ρ': "r", 'σ': "s", 'ς': "s", 'τ': "t", 'υ': "y", 'φ': "f", 'χ': "ch", 'ψ': "ps",
+	'ω': "o",
+	'Α': "A", 'Β': "V", 'Γ': "G", 'Δ': "D", 'Ε': "E", 'Ζ': "Z", 'Η': "I", 'Θ': "Th",
+	'Ι': "I", 'Κ': "K", 'Λ': "L", 'Μ': "M", 'Ν': "N", 'Ξ': "X", 'Ο': "O", 'Π': "P",
+	'Ρ': "R", 'Σ': "S", 'Τ': "T", 'Υ': "Y", 'Φ': "F", 'Χ': "Ch", 'Ψ': "Ps", 'Ω': "O",
+}
+
+// Transliterate returns s written in latin letters, diacritics are removed
+// and cyrillic and greek letters are replaced by their latin counterpart
+func Transliterate(s string) string {
+	// letters like й and ё decompose into a different letter, so they are
+	// replaced first and decomposed greek letters are replaced afterwards
+	return replaceLetters(removeAccents(replaceLetters(s)))
+}
+
+func replaceLetters(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		if latin, ok := transliterations[r]; ok {
+			sb.WriteString(latin)
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// SafeName returns s in a form that can be used as a file or directory name,
+// when unicode is false the name is transliterated to ASCII, otherwise
+// letters and digits of all scripts are kept
+func SafeName(s string, unicode bool) string {
+	if unicode {
+		s = unicodeUnsafe.ReplaceAllString(s, "")
+	} else {
+		s = filenameSafe.ReplaceAllString(Transliterate(s), "")
+	}
+	s = strings.TrimSpace(s)
+	if len(s) > maxNameLength {
+		s = truncateBytes(s, maxNameLength)
+	}
+	return s
+}
+
+// truncateBytes shortens s to at most n bytes without splitting a character
+func truncateBytes(s string, n int) string {
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return strings.TrimRightFunc(s[:n], unicode.IsSpace)
+}
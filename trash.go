@@ -0,0 +1,19 @@
+package booksing
+
+import (
+	"time"
+)
+
+// TrashedBook is a deleted book that can still be restored until it is purged
+type TrashedBook struct {
+	// ID is unique for every time a book is trashed, the same book can be
+	// imported and trashed again while an earlier copy is still in the trash
+	ID        string `storm:"id"`
+	Hash      string
+	Book      Book
+	TrashPath string
+	// TrashFiles holds the trash location of each attached file
+	TrashFiles []string
+	Deleted    time.Time `storm:"index"`
+	DeletedBy  string
+}
@@ -53,6 +53,40 @@ type BookCount struct {
 }
 
 type SearchResult struct {
-	Items []Book
-	Total int64
+	Items      []Book
+	Total      int64
+	Facets     []Facet
+	Highlights map[string][]Highlight
+}
+
+// IndexProgress is the progress of a rebuild of the search index
+type IndexProgress struct {
+	Running  bool
+	Started  time.Time
+	Finished time.Time
+	Done     int
+	Total    int
+	Error    string
+}
+
+// Highlight holds the parts of a field that matched a search, with the
+// matched terms wrapped in <mark> tags and the rest HTML escaped
+type Highlight struct {
+	Field     string
+	Fragments []string
+}
+
+// Facet counts the values of a field in the books that match a search
+type Facet struct {
+	Field  string
+	Title  string
+	Values []FacetValue
+}
+
+// FacetValue is a single value of a facet, Filter is the query term that
+// narrows the search down to that value
+type FacetValue struct {
+	Value  string
+	Count  int
+	Filter string
 }
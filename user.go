@@ -19,3 +19,43 @@ type Bookmark struct {
 	Icon       ShelveIcon
 	LastChange time.Time
 }
+
+// Icon returns the shelf the user put a book on, or the default shelf
+func (u *User) Icon(hash string) ShelveIcon {
+	bm, ok := u.Bookmarks[hash]
+	if !ok {
+		return DefaultShelveIcon()
+	}
+	return bm.Icon
+}
+
+// SetIcons sets the icon of every book to the shelf of the user
+func (u *User) SetIcons(books []Book) {
+	for i := range books {
+		books[i].Icon = u.Icon(books[i].Hash)
+	}
+}
+
+// Shelf returns the hashes of the books the user put on a shelf
+func (u *User) Shelf(icon ShelveIcon) []string {
+	var hashes []string
+	for hash, bm := range u.Bookmarks {
+		if bm.Icon == icon {
+			hashes = append(hashes, hash)
+		}
+	}
+	return hashes
+}
+
+// SetShelf puts a book on a shelf, a book on the default shelf is no longer
+// bookmarked
+func (u *User) SetShelf(hash string, icon ShelveIcon) {
+	if icon == DefaultShelveIcon() {
+		delete(u.Bookmarks, hash)
+		return
+	}
+	u.Bookmarks[hash] = Bookmark{
+		Icon:       icon,
+		LastChange: time.Now(),
+	}
+}
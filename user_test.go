@@ -0,0 +1,26 @@
+package booksing
+
+import (
+	"fmt"
+	"testing"
+)
+
+func Test_setShelf(t *testing.T) {
+	u := User{Bookmarks: make(map[string]Bookmark)}
+	read, _ := ParseShelf("read")
+
+	u.SetShelf("book", read)
+	if u.Icon("book") != read {
+		t.Errorf("SetShelf() put the book on %s, want %s", u.Icon("book"), read)
+	}
+	u.SetShelf("book", DefaultShelveIcon())
+	if _, ok := u.Bookmarks["book"]; ok {
+		t.Errorf("SetShelf() kept a bookmark for the default shelf")
+	}
+}
+
+func Test_shelfNames(t *testing.T) {
+	if got := fmt.Sprint(ShelfNames()); got != "[want reading read stopped]" {
+		t.Errorf("ShelfNames() = %s, want [want reading read stopped]", got)
+	}
+}